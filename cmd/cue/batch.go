@@ -0,0 +1,146 @@
+package cue
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/iSerganov/gocue/pkg/cue"
+)
+
+// Batch flags
+var (
+	filesFrom string
+	workers   int
+)
+
+var batchCmd = &cobra.Command{
+	Use:   "batch [files...]",
+	Short: "Analyse many audio files concurrently, results as JSON Lines",
+	Long: `Analyse many audio files concurrently and print one JSON object per line, holding the file "path",
+an "error" if the analysis failed, and the usual result fields otherwise. Lines are printed in completion order.
+With --format yaml, csv or tsv, every file is a YAML document or a table row with the same "path" and "error" fields.
+
+Files are taken from the arguments and from --files_from. Without either, file names are read from stdin, one per line.
+The exit status is non-zero if any file failed; a summary of the failures is printed to stderr.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		calc := newCalculator(cmd)
+
+		var list io.Reader
+		switch {
+		case filesFrom == "-" || (filesFrom == "" && len(args) == 0):
+			list = os.Stdin
+		case filesFrom != "":
+			f, err := os.Open(filesFrom)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				os.Exit(1)
+			}
+			defer func() { _ = f.Close() }()
+			list = f
+		}
+
+		sum := runBatch(calc.CalcBatchContext(cmd.Context(), feedPaths(args, list), workers), nil)
+		exitIfInterrupted(cmd)
+		if sum.failed > 0 {
+			fmt.Fprintf(os.Stderr, "%d of %d files failed\n", sum.failed, sum.analysed+sum.cached+sum.skipped+sum.failed)
+			os.Exit(1)
+		}
+	},
+}
+
+// feedPaths streams args followed by the non-empty lines of list (if any)
+func feedPaths(args []string, list io.Reader) <-chan string {
+	paths := make(chan string)
+	go func() {
+		defer close(paths)
+		for _, a := range args {
+			paths <- a
+		}
+		if list == nil {
+			return
+		}
+		scanner := bufio.NewScanner(list)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				paths <- line
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "error while reading the file list: %s\n", err)
+		}
+	}()
+	return paths
+}
+
+// batchSummary - per-outcome file counts of a batch run
+type batchSummary struct {
+	analysed int
+	cached   int
+	skipped  int
+	failed   int
+}
+
+// runBatch prints one record per analysed or cached file and returns the
+// outcome counts. Files rejected by --max_duration are only counted. If keep
+// is set, it is called for every result.
+func runBatch(results <-chan cue.BatchResult, keep func(cue.BatchResult)) (sum batchSummary) {
+	out := bufio.NewWriter(os.Stdout)
+	defer func() { _ = out.Flush() }()
+	rw := newOutput(out, true)
+
+	for res := range results {
+		if keep != nil {
+			keep(res)
+		}
+		switch {
+		case errors.As(res.Err, &cue.ErrTooLong{}):
+			sum.skipped++
+			continue
+		case errors.Is(res.Err, context.Canceled):
+			// aborted by a signal, see exitIfInterrupted
+			continue
+		case res.Err != nil:
+			sum.failed++
+			if !rw.reportsErrors() {
+				fmt.Fprintf(os.Stderr, "error while calculating cue/loudness parameters for %q: %s\n", res.Path, res.Err)
+			}
+		case res.Result.Cached():
+			sum.cached++
+		default:
+			sum.analysed++
+		}
+		if err := rw.write(res); err != nil {
+			fmt.Fprintf(os.Stderr, "error while marshalling the result for %q: %s\n", res.Path, err)
+		}
+		// keep the output line-buffered, so consumers see progress
+		_ = out.Flush()
+	}
+	return sum
+}
+
+// exitIfInterrupted terminates with status 130, like a shell does after
+// SIGINT, if a signal has aborted the command
+func exitIfInterrupted(cmd *cobra.Command) {
+	if cmd.Context().Err() != nil {
+		fmt.Fprintln(os.Stderr, "interrupted")
+		os.Exit(130)
+	}
+}
+
+func init() {
+	// File list
+	batchCmd.Flags().StringVarP(&filesFrom, "files_from", "i", "", "Read file names from this file, one per line, or \"-\" for stdin")
+
+	// Concurrency
+	batchCmd.Flags().IntVarP(&workers, "workers", "W", runtime.NumCPU(), "Number of files analysed concurrently")
+
+	cmd.AddCommand(batchCmd)
+}
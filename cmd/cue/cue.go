@@ -1,8 +1,11 @@
 package cue
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -18,6 +21,7 @@ var (
 	target      float64
 	silence     float64
 	overlay     float64
+	intro       float64
 	longtail    float64
 	extra       float64
 	drop        float64
@@ -26,8 +30,37 @@ var (
 	printFlags  bool
 	blankskip   float64
 	execTimeout time.Duration
+	writeTags   bool
+	writeRG     bool
+	force       bool
+	reanalyze   []string
+	jsonFile    string
+	maxDuration time.Duration
+	format      string
+	sidecar     bool
+	sidecarDir  string
+	cachePath   string
+	ffmpegPath  string
+	ffprobePath string
+	ffmpegArgs  []string
+	ffmpegOnly  bool
+	beats       bool
+	snap        string
+	snapCueIn   bool
 )
 
+// names accepted by --snap
+var snapUnits = map[string]cue.SnapTo{
+	"beat": cue.SnapBeat,
+	"bar":  cue.SnapBar,
+}
+
+// names accepted by --reanalyze
+var reanalyzeReasons = map[string]cue.ReanalyzeReason{
+	"reference":  cue.ReanalyzeReferenceLoudness,
+	"thresholds": cue.ReanalyzeThresholds,
+}
+
 var cmd = &cobra.Command{
 	Use:   "gocue [file]",
 	Short: "Analyse audio file for cue-in, cue-out, overlay and EBU R128 loudness data",
@@ -44,53 +77,102 @@ This ensures special song endings are always kept intact in transitions.
 A full audio file analysis can take some time. gocue tries to avoid a (re-)analysis if all required data can be read from existing tags in the file.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		// Validate ranges for numeric parameters
-		if err := validateRanges(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-			os.Exit(1)
-		}
+		calc := newCalculator(cmd)
 
-		if printFlags {
-			cmd.Flags().VisitAll(func(f *pflag.Flag) {
-				fmt.Printf("Flag: %s, Value: %v\n", f.Name, f.Value)
-			})
+		var (
+			metadata map[string]string
+			err      error
+		)
+		if jsonFile != "" {
+			if metadata, err = readMetadata(jsonFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				os.Exit(1)
+			}
 		}
 
-		// Process the audio file
-		calc := cue.NewCalculator(&cue.CalculatorOptions{
-			ExecutionTimeout: execTimeout,
-			TargetLoudness:   target,
-			Silence:          silence,
-			Overlay:          overlay,
-			LongtailSeconds:  longtail,
-			Extra:            extra,
-			Drop:             drop,
-			NoClip:           noclip,
-			BlankSkip:        blankskip,
-		})
-
-		res, err := calc.Calc(args[0])
+		res, err := calc.CalcWithMetadataContext(cmd.Context(), args[0], metadata)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error while calculating cue/loudness parameters: %s\n", err)
 			os.Exit(1)
 		}
-		var (
-			jsonData []byte
-		)
-		if nice {
-			jsonData, err = res.MarshalNiceJSON()
-		} else {
-			jsonData, err = res.MarshalJSON()
-		}
-		if err != nil {
+		if err := newOutput(os.Stdout, false).write(cue.BatchResult{Path: args[0], Result: res}); err != nil {
 			fmt.Fprintf(os.Stderr, "error while marshalling the result: %s\n", err)
 			os.Exit(1)
 		}
-		_, _ = fmt.Print(string(jsonData) + "\n")
 	},
 	Version: version,
 }
 
+// newCalculator validates the analysis flags shared by all commands and builds
+// the calculator from them; invalid flags terminate the program
+func newCalculator(cmd *cobra.Command) *cue.Calculator {
+	// Validate ranges for numeric parameters
+	if err := validateRanges(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	reasons, err := parseReanalyze()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	if err := validateFormat(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	snapTo, err := parseSnap()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	var cache *cue.Cache
+	if cachePath != "" {
+		if cache, err = cue.OpenCache(cachePath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if printFlags {
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			fmt.Fprintf(os.Stderr, "Flag: %s, Value: %v\n", f.Name, f.Value)
+		})
+	}
+
+	return cue.NewCalculator(&cue.CalculatorOptions{
+		ExecutionTimeout: execTimeout,
+		TargetLoudness:   target,
+		Silence:          silence,
+		Overlay:          overlay,
+		Intro:            intro,
+		LongtailSeconds:  longtail,
+		Extra:            extra,
+		Drop:             drop,
+		NoClip:           noclip,
+		BlankSkip:        blankskip,
+		WriteTags:        writeTags,
+		WriteReplayGain:  writeRG,
+		ForceAnalysis:    force,
+		Reanalyze:        reasons,
+		MaxDuration:      maxDuration,
+		Sidecar:          sidecar,
+		SidecarDir:       sidecarDir,
+		Cache:            cache,
+		AppVersion:       version,
+		FFmpeg: cue.FFmpeg{
+			FFmpegPath:  ffmpegPath,
+			FFprobePath: ffprobePath,
+			FFmpegArgs:  ffmpegArgs,
+		},
+		FFmpegOnly: ffmpegOnly,
+		Beats:      beats,
+		Snap:       snapTo,
+		SnapCueIn:  snapCueIn,
+		Log:        os.Stderr,
+	})
+}
+
 // validateRanges validates that numeric parameters are within their allowed ranges
 func validateRanges() error {
 	if target < -23.0 || target > 0.0 {
@@ -102,6 +184,9 @@ func validateRanges() error {
 	if overlay < -96.0 || overlay > 0.0 {
 		return fmt.Errorf("overlay must be between -96.0 and 0.0, got %f", overlay)
 	}
+	if intro < -96.0 || intro > 0.0 {
+		return fmt.Errorf("intro must be between -96.0 and 0.0, got %f", intro)
+	}
 	if longtail < 0.0 || longtail > 60.0 {
 		return fmt.Errorf("longtail must be between 0.0 and 60.0, got %f", longtail)
 	}
@@ -117,46 +202,131 @@ func validateRanges() error {
 	return nil
 }
 
+// parseReanalyze converts the --reanalyze names into a cue.ReanalyzeReason set
+func parseReanalyze() (cue.ReanalyzeReason, error) {
+	var reasons cue.ReanalyzeReason
+	for _, name := range reanalyze {
+		reason, ok := reanalyzeReasons[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown reanalyze reason %q, expected reference or thresholds", name)
+		}
+		reasons |= reason
+	}
+	return reasons, nil
+}
+
+// parseSnap converts the --snap name into a cue.SnapTo unit
+func parseSnap() (cue.SnapTo, error) {
+	if snap == "" {
+		return cue.SnapOff, nil
+	}
+	unit, ok := snapUnits[snap]
+	if !ok {
+		return cue.SnapOff, fmt.Errorf("unknown snap unit %q, expected beat or bar", snap)
+	}
+	return unit, nil
+}
+
+// readMetadata reads a JSON metadata object from the named file, or from stdin for "-"
+func readMetadata(name string) (map[string]string, error) {
+	if name == "-" {
+		return cue.ParseMetadata(os.Stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return cue.ParseMetadata(f)
+}
+
 func init() {
 	// File argument is handled by Args: cobra.ExactArgs(1)
 
 	// Target LUFS reference
-	cmd.Flags().Float64VarP(&target, "target", "t", -18.0, "LUFS reference target; -23.0 to 0.0")
+	cmd.PersistentFlags().Float64VarP(&target, "target", "t", -18.0, "LUFS reference target; -23.0 to 0.0")
 
 	// Execution timeout
-	cmd.Flags().DurationVarP(&execTimeout, "exec_timeout", "e", 20*time.Second, "Script execution timeout")
+	cmd.PersistentFlags().DurationVarP(&execTimeout, "exec_timeout", "e", 20*time.Second, "Script execution timeout")
 
 	// Silence threshold
-	cmd.Flags().Float64VarP(&silence, "silence", "s", -42.0, "LU below integrated track loudness for cue-in & cue-out points (silence removal at beginning & end of a track)")
+	cmd.PersistentFlags().Float64VarP(&silence, "silence", "s", -42.0, "LU below integrated track loudness for cue-in & cue-out points (silence removal at beginning & end of a track)")
 
 	// Overlay threshold
-	cmd.Flags().Float64VarP(&overlay, "overlay", "o", -8.0, "LU below integrated track loudness to trigger next track")
+	cmd.PersistentFlags().Float64VarP(&overlay, "overlay", "o", -8.0, "LU below integrated track loudness to trigger next track")
+
+	// Intro threshold
+	cmd.PersistentFlags().Float64Var(&intro, "intro", -6.0, "LU below integrated track loudness the track has to stay above for 2 seconds to end its intro (liq_intro_end, for talking over intros)")
 
 	// Longtail duration
-	cmd.Flags().Float64VarP(&longtail, "longtail", "l", 15.0, "More than so many seconds of calculated overlay duration are considered a long tail, and will force a recalculation using --extra, thus keeping long song endings intact")
+	cmd.PersistentFlags().Float64VarP(&longtail, "longtail", "l", 15.0, "More than so many seconds of calculated overlay duration are considered a long tail, and will force a recalculation using --extra, thus keeping long song endings intact")
 
 	// Extra LU for longtail
-	cmd.Flags().Float64VarP(&extra, "extra", "x", -12.0, "Extra LU below overlay loudness to trigger next track for songs with long tail")
+	cmd.PersistentFlags().Float64VarP(&extra, "extra", "x", -12.0, "Extra LU below overlay loudness to trigger next track for songs with long tail")
 
 	// Sustained loudness drop
-	cmd.Flags().Float64VarP(&drop, "drop", "d", 40.0, "Max. percent loudness drop at the end to be still considered having a sustained ending. Such tracks will be recalculated using --extra, keeping the song ending intact. Zero (0.0) to switch off.")
+	cmd.PersistentFlags().Float64VarP(&drop, "drop", "d", 40.0, "Max. percent loudness drop at the end to be still considered having a sustained ending. Such tracks will be recalculated using --extra, keeping the song ending intact. Zero (0.0) to switch off.")
 
 	// No clip prevention
-	cmd.Flags().BoolVarP(&noclip, "noclip", "k", false, "Clipping prevention: Lowers track gain if needed, to avoid peaks going above -1 dBFS. Uses true peak values of all audio channels.")
+	cmd.PersistentFlags().BoolVarP(&noclip, "noclip", "k", false, "Clipping prevention: Lowers track gain if needed, to avoid peaks going above -1 dBFS. Uses true peak values of all audio channels.")
 
 	// Nice output
 	cmd.Flags().BoolVarP(&nice, "nice", "n", false, "Pretty-print JSON output")
 
+	// Output format
+	cmd.PersistentFlags().StringVar(&format, "format", formatJSON, "Output format: json, jsonl, yaml, csv, tsv, or annotate for a Liquidsoap annotate:key=\"value\",...:path request URI")
+
 	// Blank skip
-	cmd.Flags().Float64VarP(&blankskip, "blankskip", "b", 0.0, "Skip blank (silence) within track if longer than [BLANKSKIP] seconds (get rid of \"hidden tracks\"). Sets the cue-out point to where the silence begins. Don't use this with spoken or TTS-generated text, as it will often cut the message short. Zero (0.0) to switch off.")
+	cmd.PersistentFlags().Float64VarP(&blankskip, "blankskip", "b", 0.0, "Skip blank (silence) within track if longer than [BLANKSKIP] seconds (get rid of \"hidden tracks\"). Sets the cue-out point to where the silence begins. Don't use this with spoken or TTS-generated text, as it will often cut the message short. Zero (0.0) to switch off.")
+
+	// Tag write-back
+	cmd.PersistentFlags().BoolVarP(&writeTags, "write_tags", "w", false, "Write liq_* tags back to the audio file after an analysis, so later runs can skip it")
+
+	// ReplayGain write-back
+	cmd.PersistentFlags().BoolVarP(&writeRG, "write_replaygain", "r", false, "Write ReplayGain 2.0 tags (and R128_TRACK_GAIN for Opus) to the audio file after an analysis")
+
+	// Force re-analysis
+	cmd.PersistentFlags().BoolVarP(&force, "force_analysis", "f", false, "Force re-analysis, even if tags exist")
+
+	// Conditional re-analysis
+	cmd.PersistentFlags().StringSliceVar(&reanalyze, "reanalyze", nil, "Re-analyse if cached tags differ from the requested settings: reference (liq_reference_loudness), thresholds (results without a liq_gocue_params fingerprint, e.g. written by other tools); comma-separated")
+
+	// Sidecar files
+	cmd.PersistentFlags().BoolVar(&sidecar, "sidecar", false, "Read and write analysis results in a <file>.gocue.json sidecar file, e.g. for read-only music directories")
+	cmd.PersistentFlags().StringVar(&sidecarDir, "sidecar_dir", "", "Keep sidecar files in this directory, mirroring the absolute audio file paths; implies --sidecar")
+
+	// Analysis cache
+	cmd.PersistentFlags().StringVar(&cachePath, "cache", "", "Analysis cache database file; results are found by audio content, so renamed, moved or retagged files are not analysed again")
+
+	// Length limit
+	cmd.PersistentFlags().DurationVar(&maxDuration, "max_duration", 0, "Skip files longer than this (e.g. 20m), such as DJ sets or videos; zero for no limit")
+
+	// Liquidsoap JSON metadata
+	cmd.Flags().StringVarP(&jsonFile, "json", "j", "", "JSON metadata file name, or \"-\" for stdin; its tags are merged with the file tags, and user-set liq_cue_in/liq_cue_out/liq_cross_start_next values override the analysed ones")
+
+	// FFmpeg binaries
+	cmd.PersistentFlags().StringVar(&ffmpegPath, "ffmpeg", "ffmpeg", "ffmpeg binary used for analysis and tag writing")
+	cmd.PersistentFlags().StringVar(&ffprobePath, "ffprobe", "ffprobe", "ffprobe binary used for reading tags")
+	cmd.PersistentFlags().StringArrayVar(&ffmpegArgs, "ffmpeg_arg", nil, "Extra ffmpeg option, placed before the input; repeat for several, e.g. --ffmpeg_arg=-threads --ffmpeg_arg=1")
+	cmd.PersistentFlags().BoolVar(&ffmpegOnly, "ffmpeg_only", false, "Use ffmpeg and ffprobe for WAV, AIFF and FLAC files too, instead of decoding them natively")
+
+	// Beat grid flags
+	cmd.PersistentFlags().BoolVar(&beats, "beats", false, "Detect the tempo and beat grid (liq_bpm, liq_first_beat, liq_first_downbeat); needs another decoding pass for files analysed with ffmpeg")
+	cmd.PersistentFlags().StringVar(&snap, "snap", "", "Snap liq_cross_start_next to the nearest beat or bar (downbeat) of the beat grid, reporting the analysed value as liq_cross_start_next_raw; implies --beats")
+	cmd.PersistentFlags().BoolVar(&snapCueIn, "snap_cue_in", false, "Also move liq_cue_in to the beat or bar at or before it, reporting the analysed value as liq_cue_in_raw; snaps to beats unless --snap says otherwise")
 
 	// Log all flags
-	cmd.Flags().BoolVarP(&printFlags, "print_flags", "p", false, "Log all flags")
+	cmd.PersistentFlags().BoolVarP(&printFlags, "print_flags", "p", false, "Log all flags")
 }
 
 // Execute - useful work gets done here
 func Execute() {
-	if err := cmd.Execute(); err != nil {
+	// the first SIGINT/SIGTERM aborts the running analyses, killing their
+	// ffmpeg processes; a second one terminates at once
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	defer stop()
+	if err := cmd.ExecuteContext(ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "Whoops. There was an error while executing your CLI '%s'", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,40 @@
+package cue
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CmdSuite struct {
+	suite.Suite
+}
+
+func TestCmdSuite(t *testing.T) {
+	suite.Run(t, &CmdSuite{})
+}
+
+// TestHelp runs --help on every command, which merges the persistent flags
+// into each subcommand and panics on clashing shorthands.
+func (s *CmdSuite) TestHelp() {
+	args := [][]string{{"--help"}}
+	for _, sub := range cmd.Commands() {
+		args = append(args, []string{sub.Name(), "--help"})
+	}
+	for _, a := range args {
+		s.Run(a[0], func() {
+			var out bytes.Buffer
+			cmd.SetOut(&out)
+			cmd.SetErr(&out)
+			cmd.SetArgs(a)
+			defer func() {
+				cmd.SetOut(nil)
+				cmd.SetErr(nil)
+				cmd.SetArgs(nil)
+			}()
+			s.Require().NotPanics(func() { s.Require().NoError(cmd.Execute()) })
+			s.Contains(out.String(), "Usage:")
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package cue
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/iSerganov/gocue/pkg/cue"
+)
+
+// output formats accepted by --format
+const (
+	formatJSON     = "json"
+	formatJSONL    = "jsonl"
+	formatYAML     = "yaml"
+	formatCSV      = "csv"
+	formatTSV      = "tsv"
+	formatAnnotate = "annotate"
+)
+
+var outputFormats = []string{formatJSON, formatJSONL, formatYAML, formatCSV, formatTSV, formatAnnotate}
+
+// resultWriter prints results in the selected --format. In batch mode (withPath)
+// every record carries the file path and error of every file: one object per
+// line for JSON, one document per file for YAML and one row per file for CSV/TSV.
+type resultWriter struct {
+	w        io.Writer
+	format   string
+	withPath bool
+
+	// CSV/TSV output; the header is written before the first row
+	table  *csv.Writer
+	header bool
+}
+
+// validateFormat checks the --format flag
+func validateFormat() error {
+	if !slices.Contains(outputFormats, format) {
+		return fmt.Errorf("unknown output format %q, expected one of %v", format, outputFormats)
+	}
+	return nil
+}
+
+// newOutput returns a writer for the --format flag, which newCalculator has validated
+func newOutput(w io.Writer, withPath bool) *resultWriter {
+	rw := &resultWriter{w: w, format: format, withPath: withPath}
+	switch format {
+	case formatCSV:
+		rw.table = csv.NewWriter(w)
+	case formatTSV:
+		rw.table = csv.NewWriter(w)
+		rw.table.Comma = '\t'
+	}
+	return rw
+}
+
+// reportsErrors tells whether failed files show up in the output; otherwise
+// the caller reports them on stderr
+func (rw *resultWriter) reportsErrors() bool {
+	return rw.withPath && rw.format != formatAnnotate
+}
+
+// write prints a single result. Failed files are only written if reportsErrors.
+func (rw *resultWriter) write(res cue.BatchResult) error {
+	if res.Err != nil && !rw.reportsErrors() {
+		return nil
+	}
+	var (
+		out []byte
+		err error
+	)
+	switch rw.format {
+	case formatAnnotate:
+		var uri string
+		uri, err = res.Result.AnnotateURI(res.Path)
+		out = []byte(uri)
+	case formatCSV, formatTSV:
+		return rw.writeRow(res)
+	case formatYAML:
+		if rw.withPath {
+			out, err = res.MarshalYAML()
+			out = append([]byte("---\n"), out...)
+		} else {
+			out, err = res.Result.MarshalYAML()
+		}
+		// yaml documents already end with a newline
+		out = bytes.TrimSuffix(out, []byte("\n"))
+	default:
+		switch {
+		case rw.withPath:
+			out, err = json.Marshal(res)
+		case nice && rw.format == formatJSON:
+			out, err = res.Result.MarshalNiceJSON()
+		default:
+			out, err = res.Result.MarshalJSON()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = rw.w.Write(append(out, '\n'))
+	return err
+}
+
+// writeRow writes a CSV/TSV row, preceded by the header on the first call
+func (rw *resultWriter) writeRow(res cue.BatchResult) error {
+	if !rw.header {
+		header := cue.ResultColumns()
+		if rw.withPath {
+			header = append([]string{"path", "error"}, header...)
+		}
+		if err := rw.table.Write(header); err != nil {
+			return err
+		}
+		rw.header = true
+	}
+
+	var row []string
+	if rw.withPath {
+		row = []string{res.Path, ""}
+		if res.Err != nil {
+			row[1] = res.Err.Error()
+		}
+	}
+	if res.Result != nil {
+		row = append(row, res.Result.Row()...)
+	} else {
+		row = append(row, make([]string, len(cue.ResultColumns()))...)
+	}
+	if err := rw.table.Write(row); err != nil {
+		return err
+	}
+	rw.table.Flush()
+	return rw.table.Error()
+}
@@ -0,0 +1,83 @@
+package cue
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/spf13/cobra"
+
+	"github.com/iSerganov/gocue/pkg/cue"
+)
+
+// Playlist flags
+var annotatedOut string
+
+var playlistCmd = &cobra.Command{
+	Use:   "playlist <file>",
+	Short: "Analyse all entries of an M3U, M3U8, PLS or XSPF playlist",
+	Long: `Analyse all entries of an M3U/M3U8, PLS or XSPF playlist and print one JSON object per line, like the batch command.
+Relative paths are resolved against the playlist's directory. Entries that already are Liquidsoap "annotate:" URIs
+are analysed with their annotations as JSON metadata, so user-set cue points are kept.
+
+With --output, an extended M3U playlist is written in the original order, each entry being an
+annotate:liq_cue_in="...",liq_cue_out="...",...:path URI carrying the results.
+Entries that failed are written without new annotations.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		calc := newCalculator(cmd)
+		entries, err := cue.ReadPlaylist(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		jobs := make(chan cue.BatchJob)
+		go func() {
+			defer close(jobs)
+			for _, e := range entries {
+				jobs <- cue.BatchJob{Path: e.Path, Metadata: e.Annotations}
+			}
+		}()
+		results := make([]*cue.Result, len(entries))
+		sum := runBatch(calc.CalcJobsContext(cmd.Context(), jobs, workers), func(res cue.BatchResult) {
+			results[res.Index] = res.Result
+		})
+		// a partly annotated playlist is not written
+		exitIfInterrupted(cmd)
+
+		if annotatedOut != "" {
+			if err := writeAnnotated(annotatedOut, entries, results); err != nil {
+				fmt.Fprintf(os.Stderr, "error while writing the annotated playlist: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		if sum.failed > 0 {
+			fmt.Fprintf(os.Stderr, "%d of %d entries failed\n", sum.failed, len(entries))
+			os.Exit(1)
+		}
+	},
+}
+
+// writeAnnotated writes the annotated M3U playlist to name
+func writeAnnotated(name string, entries []cue.PlaylistEntry, results []*cue.Result) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := cue.WriteAnnotatedM3U(f, entries, results); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func init() {
+	// Annotated playlist
+	playlistCmd.Flags().StringVarP(&annotatedOut, "output", "O", "", "Write an annotated M3U playlist to this file")
+
+	// Concurrency
+	playlistCmd.Flags().IntVarP(&workers, "workers", "W", runtime.NumCPU(), "Number of entries analysed concurrently")
+
+	cmd.AddCommand(playlistCmd)
+}
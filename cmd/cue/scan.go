@@ -0,0 +1,84 @@
+package cue
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/spf13/cobra"
+
+	"github.com/iSerganov/gocue/pkg/cue"
+)
+
+// Scan flags
+var (
+	include    []string
+	exclude    []string
+	extensions []string
+)
+
+var scanCmd = &cobra.Command{
+	Use:   "scan <dir>...",
+	Short: "Recursively analyse all audio files below the given directories",
+	Long: `Recursively analyse all audio files below the given directories and print one JSON object per line,
+like the batch command. Files whose existing tags already hold all required data are not re-analysed.
+
+Only files with a known audio extension are considered (see --ext); hidden files and directories are skipped.
+--include and --exclude take globs, matched against the file name and against the path relative to the scanned directory.
+Symbolic links to files are followed, links to directories are not. Directories that cannot be read are reported and skipped.
+
+A summary of analysed, cached, skipped (--max_duration) and failed files is printed to stderr.
+The exit status is non-zero if any file failed.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, g := range append(append([]string{}, include...), exclude...) {
+			if _, err := filepath.Match(g, ""); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid glob %q: %s\n", g, err)
+				os.Exit(1)
+			}
+		}
+		calc := newCalculator(cmd)
+		opts := &cue.WalkOptions{
+			Extensions: extensions,
+			Include:    include,
+			Exclude:    exclude,
+		}
+
+		paths := make(chan string)
+		walkFailed := false
+		go func() {
+			defer close(paths)
+			for _, root := range args {
+				err := cue.WalkAudioFiles(root, opts, func(path string) error {
+					paths <- path
+					return nil
+				})
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error while scanning %q: %s\n", root, err)
+					walkFailed = true
+				}
+			}
+		}()
+
+		sum := runBatch(calc.CalcBatchContext(cmd.Context(), paths, workers), nil)
+		fmt.Fprintf(os.Stderr, "analysed: %d, cached: %d, skipped: %d, failed: %d\n",
+			sum.analysed, sum.cached, sum.skipped, sum.failed)
+		exitIfInterrupted(cmd)
+		if sum.failed > 0 || walkFailed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	// File selection
+	scanCmd.Flags().StringSliceVar(&include, "include", nil, "Only analyse files matching one of these globs; comma-separated")
+	scanCmd.Flags().StringSliceVar(&exclude, "exclude", nil, "Skip files and directories matching any of these globs; comma-separated")
+	scanCmd.Flags().StringSliceVar(&extensions, "ext", cue.DefaultExtensions, "Audio file extensions to consider; comma-separated")
+
+	// Concurrency
+	scanCmd.Flags().IntVarP(&workers, "workers", "W", runtime.NumCPU(), "Number of files analysed concurrently")
+
+	cmd.AddCommand(scanCmd)
+}
@@ -0,0 +1,146 @@
+package cue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// aiffChunks lists the top-level chunks of an AIFF or AIFC file, which are
+// laid out like RIFF chunks but big-endian, and reports whether it is AIFC.
+func aiffChunks(r io.ReadSeeker) (chunks []riffChunk, aifc bool, err error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, false, err
+	}
+	form := string(hdr[8:12])
+	if string(hdr[0:4]) != "FORM" || (form != "AIFF" && form != "AIFC") {
+		return nil, false, errors.New("not an AIFF file")
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, false, err
+	}
+	for offset := int64(12); offset+8 <= end; {
+		var ch [8]byte
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, false, err
+		}
+		if _, err := io.ReadFull(r, ch[:]); err != nil {
+			return nil, false, err
+		}
+		size := int64(binary.BigEndian.Uint32(ch[4:8]))
+		total := min(8+size+size%2, end-offset)
+		chunks = append(chunks, riffChunk{id: string(ch[0:4]), offset: offset, size: total})
+		offset += total
+	}
+	return chunks, form == "AIFC", nil
+}
+
+// AIFC compression types of uncompressed audio
+var aiffSampleFormats = map[string]sampleFormat{
+	"NONE": {bigEndian: true},
+	"twos": {bigEndian: true},
+	"sowt": {},
+	"fl32": {size: 4, float: true, bigEndian: true},
+	"FL32": {size: 4, float: true, bigEndian: true},
+	"fl64": {size: 8, float: true, bigEndian: true},
+	"FL64": {size: 8, float: true, bigEndian: true},
+}
+
+// openAIFF returns the audio of an AIFF file or an uncompressed AIFC file.
+func openAIFF(r pcmFile) (*pcmStream, error) {
+	chunks, aifc, err := aiffChunks(r)
+	if err != nil {
+		return nil, err
+	}
+	var comm, ssnd *riffChunk
+	for i := range chunks {
+		switch chunks[i].id {
+		case "COMM":
+			comm = &chunks[i]
+		case "SSND":
+			ssnd = &chunks[i]
+		}
+	}
+	if comm == nil || ssnd == nil || ssnd.size < 16 {
+		return nil, errors.New("missing AIFF COMM or SSND chunk")
+	}
+
+	b := make([]byte, min(comm.size-8, 22))
+	if _, err := r.ReadAt(b, comm.offset+8); err != nil {
+		return nil, err
+	}
+	if len(b) < 18 || (aifc && len(b) < 22) {
+		return nil, errors.New("truncated AIFF COMM chunk")
+	}
+	channels := int(binary.BigEndian.Uint16(b[0:2]))
+	frames := int64(binary.BigEndian.Uint32(b[2:6]))
+	bits := int(binary.BigEndian.Uint16(b[6:8]))
+	sampleRate := int(math.Round(extendedFloat(b[8:18])))
+	compression := "NONE"
+	if aifc {
+		compression = string(b[18:22])
+	}
+	format, ok := aiffSampleFormats[compression]
+	if !ok {
+		return nil, fmt.Errorf("unsupported AIFC compression %q", compression)
+	}
+	if !format.float {
+		format.size = (bits + 7) / 8
+	}
+
+	var offset [4]byte
+	if _, err := r.ReadAt(offset[:], ssnd.offset+8); err != nil {
+		return nil, err
+	}
+	start := ssnd.offset + 16 + int64(binary.BigEndian.Uint32(offset[:]))
+	length := max(0, min(frames*int64(format.size*channels), ssnd.offset+ssnd.size-start))
+	s, err := newPCMStream(io.NewSectionReader(r, start, length), sampleRate, channels, format)
+	if err != nil {
+		return nil, err
+	}
+	s.frames = frames
+	return s, nil
+}
+
+// extendedFloat decodes the 80-bit IEEE 754 extended precision number AIFF
+// stores the sample rate as.
+func extendedFloat(b []byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b[0:2]) & 0x7fff)
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	v := math.Ldexp(float64(mantissa), exp-16383-63)
+	if b[0]&0x80 != 0 {
+		v = -v
+	}
+	return v
+}
+
+// readAIFFTags returns the TXXX frames of the "ID3 " chunk of an AIFF file,
+// where ffmpeg writes its tags.
+func readAIFFTags(pathToFile string) (map[string]string, error) {
+	f, err := os.Open(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	chunks, _, err := aiffChunks(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+	for _, ch := range chunks {
+		if bytes.EqualFold([]byte(ch.id), id3ChunkID) {
+			t, err := readID3Tag(io.NewSectionReader(f, ch.offset+8, ch.size-8))
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+			}
+			return t.txxx(), nil
+		}
+	}
+	return map[string]string{}, nil
+}
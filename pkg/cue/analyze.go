@@ -0,0 +1,416 @@
+package cue
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+const (
+	// shortest recommended fade, also Liquidsoap's usual default fade-in
+	minFadeDuration = 0.1
+	// fade-out assumed for cached tags written before fades were computed,
+	// matching Liquidsoap's usual default
+	defaultFadeOut = 2.5
+	// LU per second the loudness falls at least from the overlap point to
+	// cue-out on a cold end; a hard stop passes the 400 ms momentary window
+	// at about 100 LU/s, while even short fades take seconds
+	coldEndSlope = 30.0
+	// LU around its median the loudness stays within for at least half of a
+	// sustained ending
+	sustainedRangeLU = 3.0
+)
+
+// liq_ending values: how the track ends, so that a hard cut can be chosen over
+// a crossfade
+const (
+	// EndingCold - the track stops abruptly
+	EndingCold = "cold"
+	// EndingFade - the track fades or decays by itself
+	EndingFade = "fade"
+	// EndingSustained - the track holds its level until it stops
+	EndingSustained = "sustained"
+	// EndingLongTail - the track decays slowly over more than the long tail
+	// limit (liq_longtail)
+	EndingLongTail = "longtail"
+)
+
+// TruePeakInfo - whole-track measurements reported by the ebur128 filter
+// after the last frame, next to the integrated loudness
+type TruePeakInfo struct {
+	// TruePeak is the highest true peak of all channels, linear (1.0 = 0 dBFS)
+	TruePeak float64
+	// TruePeakDb is TruePeak in dBFS; -Inf for digital silence
+	TruePeakDb float64
+	// LoudnessRange is the EBU R128 loudness range (LRA) in LU
+	LoudnessRange float64
+}
+
+// NewTruePeakInfo - builds a TruePeakInfo from the linear true peak and the
+// loudness range, deriving the dBFS value
+func NewTruePeakInfo(truePeak, loudnessRange float64) TruePeakInfo {
+	tp := TruePeakInfo{TruePeak: truePeak, TruePeakDb: math.Inf(-1), LoudnessRange: loudnessRange}
+	if truePeak > 0 {
+		tp.TruePeakDb = 20 * math.Log10(truePeak)
+	}
+	return tp
+}
+
+// Analyze - derives the cue points, overlay point, fades and gain from the
+// momentary loudness of 100 ms frames (in PTS order, as produced by
+// ffmpeg's ebur128 filter), the integrated loudness in LUFS and the
+// whole-track true peak, using the thresholds and loudness target of opts
+// (start from DefaultCalculatorOptions; zero values are used as they are).
+// The duration is taken from the last frame. Without onsets, no beat grid is
+// detected or snapped to. Diagnostics only go to opts.Log. Returns nil for no
+// frames.
+func Analyze(frames []Frame, integrated float64, tp TruePeakInfo, opts CalculatorOptions) *Result {
+	return NewCalculator(&opts).analyze(&profile{frames: frames, integrated: integrated, tp: tp})
+}
+
+// analyze derives all cueing and loudness values from a loudness profile.
+func (c Calculator) analyze(p *profile) *Result {
+	frames, loudness := p.frames, p.integrated
+	if len(frames) == 0 {
+		return nil
+	}
+
+	// internal duration from the last analysed frame, rounded to 2 decimals (the
+	// reported duration is overridden with the precise probe value in Calc)
+	duration := math.Round((frames[len(frames)-1].PTSTime+0.1)*100) / 100
+
+	// Find cue-in: first frame whose momentary loudness exceeds "silence".
+	silenceLevel := loudness + c.silence
+	cueInTime := 0.0
+	start := 0
+	end := len(frames)
+	for i := start; i < end; i++ {
+		if frames[i].Loudness > silenceLevel {
+			cueInTime = frames[i].PTSTime
+			start = i
+			break
+		}
+	}
+	// EBU R128 measures over the trailing 400ms; clamp an early cue-in to 0.
+	if cueInTime < 0.4 {
+		cueInTime = 0.0
+	}
+
+	// We use start/end pointers into frames so cue-out, overlay and long-tail
+	// can be searched forwards/backwards and handle early cue-outs from blanks.
+	cueOutTime := 0.0
+	cueOutTimeBlank := 0.0
+	endBlank := end
+
+	// Cue-out on an in-track silence ("hidden tracks"): scan forward for a
+	// silence at least blankSkip seconds long.
+	if c.blankSkip > 0 {
+		i := start
+		for i < end {
+			if frames[i].Loudness <= silenceLevel {
+				cueOutTimeBlankStart := frames[i].PTSTime
+				cueOutTimeBlankStop := frames[i].PTSTime + c.blankSkip
+				endBlank = i + 1
+				for i < end && frames[i].Loudness <= silenceLevel && frames[i].PTSTime <= cueOutTimeBlankStop {
+					i++
+				}
+				if i >= end {
+					endBlank = end // ran into end of track
+					break
+				}
+				if frames[i].PTSTime >= cueOutTimeBlankStop {
+					cueOutTimeBlank = cueOutTimeBlankStart // silence long enough
+					break
+				}
+				// too short: reset endBlank so this candidate can't truncate the
+				// window, then keep searching (a later qualifying blank re-sets it)
+				endBlank = end
+				i++
+			} else {
+				i++
+			}
+		}
+	}
+
+	// Normal cue-out: last frame above "silence", scanning from the end.
+	if idx, t := firstIndexAboveFromEnd(frames, start, end, silenceLevel); idx >= 0 {
+		cueOutTime = t
+		end = idx + 1
+	}
+	cueOutTime = math.Max(cueOutTime, duration-cueOutTime)
+
+	blankSkipped := false
+	if c.blankSkip > 0 {
+		if 0.0 < cueOutTimeBlank && cueOutTimeBlank < cueOutTime {
+			cueOutTime = cueOutTimeBlank
+			blankSkipped = true
+		}
+		end = endBlank
+	}
+
+	// Overlap point (where the next song starts): last frame above the overlay
+	// level, scanning from the end.
+	cueDuration := cueOutTime - cueInTime
+	startNextLevel := loudness + c.overlay
+	startNextTime := 0.0
+	startNextIdx := end
+	if idx, t := firstIndexAboveFromEnd(frames, start, end, startNextLevel); idx >= 0 {
+		startNextTime = t
+		startNextIdx = idx
+	}
+	startNextTime = math.Max(startNextTime, cueOutTime-startNextTime)
+
+	// Sustained ending: if the loudness drop at the end is small, re-find the
+	// overlap point using max(end loudness, overlay+extra) to keep it intact.
+	sustained := false
+	startNextTimeSustained := 0.0
+	if startNextIdx < end {
+		lufsRatioPct, endLufs := calcEnding(frames[startNextIdx:end])
+		c.logf("Overlay: %.2f LUFS, Longtail: %.2f LUFS, Measured end avg: %.2f LUFS, Drop: %.2f%%\n",
+			loudness+c.overlay, loudness+c.overlay+c.extra, endLufs, lufsRatioPct)
+		if lufsRatioPct < c.drop {
+			sustained = true
+			startNextLevel = math.Max(endLufs, loudness+c.overlay+c.extra)
+			if idx, t := firstIndexAboveFromEnd(frames, start, end, startNextLevel); idx >= 0 {
+				startNextTimeSustained = t
+			}
+			startNextTimeSustained = math.Max(startNextTimeSustained, cueOutTime-startNextTimeSustained)
+		}
+	} else {
+		c.logf("Already at end of track (badly cut?), no ending to analyse.\n")
+	}
+
+	// Long tail: if the computed overlap is longer than longtailSeconds, re-find
+	// the overlap point using overlay+extra to keep a long fade-out intact.
+	longtail := false
+	startNextTimeLongtail := 0.0
+	if (cueOutTime - startNextTime) > c.longtailSeconds {
+		longtail = true
+		startNextLevel = loudness + c.overlay + c.extra
+		if idx, t := firstIndexAboveFromEnd(frames, start, end, startNextLevel); idx >= 0 {
+			startNextTimeLongtail = t
+		}
+		startNextTimeLongtail = math.Max(startNextTimeLongtail, cueOutTime-startNextTimeLongtail)
+	}
+
+	ending := classifyEnding(frames[min(startNextIdx, end):end], longtail)
+
+	// Use the latest of the three overlap candidates (keeps endings intact).
+	startNextTimeNew := math.Max(math.Max(startNextTime, startNextTimeSustained), startNextTimeLongtail)
+	c.logf("Overlay times: %.2f/%.2f/%.2f s (normal/sustained/longtail), using: %.2fs.\n",
+		startNextTime, startNextTimeSustained, startNextTimeLongtail, startNextTimeNew)
+	startNextTime = startNextTimeNew
+	c.logf("Cue out time: %.2f s\n", cueOutTime)
+
+	cueInRaw, startNextRaw := cueInTime, startNextTime
+	cueInTime, startNextTime = c.snapToBeats(p.beats, cueInTime, cueOutTime, startNextTime)
+	cueDuration = cueOutTime - cueInTime
+
+	introEnd := c.calcIntroEnd(frames[start:end], loudness, cueInTime, startNextTime)
+	fadeIn, fadeOut := c.calcFades(frames[start:end], loudness, cueInTime, cueOutTime, startNextTime, sustained)
+
+	amplify, amplifyCorrection := c.calcAmplify(loudness, p.tp.TruePeakDb)
+
+	res := &Result{
+		CueDuration:       cueDuration,
+		CueIn:             cueInTime,
+		IntroEnd:          introEnd,
+		CueOut:            cueOutTime,
+		CrossStartNext:    startNextTime,
+		CrossDuration:     cueOutTime - startNextTime,
+		FadeIn:            fadeIn,
+		FadeOut:           fadeOut,
+		LongTail:          longtail,
+		SustainedEnding:   sustained,
+		Ending:            ending,
+		Loudness:          fmt.Sprintf("%.3f LUFS", loudness),
+		LoudnessRange:     fmt.Sprintf("%.3f LU", p.tp.LoudnessRange),
+		Amplify:           fmt.Sprintf("%.3f dB", amplify),
+		AmplifyAdjustment: fmt.Sprintf("%.3f dB", amplifyCorrection),
+		ReferenceLoudness: fmt.Sprintf("%.3f LUFS", c.targetLoudness),
+		BlankSkip:         c.blankSkip,
+		BlankSkipped:      blankSkipped,
+		Duration:          duration,
+		TruePeak:          p.tp.TruePeak,
+		TruePeakDb:        fmt.Sprintf("%.3f dBFS", p.tp.TruePeakDb),
+	}
+	if c.beats && p.beats != nil && p.beats.BPM > 0 {
+		res.BPM, res.FirstBeat, res.FirstDownbeat = p.beats.BPM, new(p.beats.FirstBeat), new(p.beats.FirstDownbeat)
+	}
+	if c.snap != SnapOff {
+		res.CueInRaw, res.CrossStartNextRaw = new(cueInRaw), new(startNextRaw)
+	}
+	return res
+}
+
+// logf writes a diagnostic line to the Log option, if set.
+func (c *Calculator) logf(format string, args ...any) {
+	if c.log != nil {
+		fmt.Fprintf(c.log, format, args...)
+	}
+}
+
+// snapToBeats moves the overlay point to the nearest beat or downbeat of the
+// grid between cue-in and cue-out, or else to the one before it, so that the
+// next track starts in time. The cue-in, if snapped as well, goes to the grid
+// point at or before it, never cutting into the audio, unless that is before
+// the start of the file: then to the first grid point. Without a grid, or a
+// steady beat, nothing is moved.
+func (c *Calculator) snapToBeats(g *BeatGrid, cueIn, cueOut, startNext float64) (float64, float64) {
+	if g == nil || g.BPM <= 0 || c.snap == SnapOff {
+		return cueIn, startNext
+	}
+	snappedCueIn := cueIn
+	if c.snapCueIn {
+		if snappedCueIn = g.previous(cueIn, c.snap); snappedCueIn < 0 {
+			snappedCueIn = g.next(0, c.snap)
+		}
+	}
+	snappedStartNext := startNext
+	for _, t := range []float64{g.nearest(startNext, c.snap), g.previous(startNext, c.snap)} {
+		if t >= snappedCueIn && t <= cueOut {
+			snappedStartNext = t
+			break
+		}
+	}
+	return snappedCueIn, snappedStartNext
+}
+
+// calcIntroEnd finds where the main body of the track begins, for talking over
+// its intro: the first frame after cue-in from which the momentary loudness
+// stays above the intro level for introSustainSeconds. Tracks that start at
+// that level, or never reach it, have no intro and return cueIn; the intro
+// never reaches into the overlap.
+func (c *Calculator) calcIntroEnd(frames []Frame, loudness, cueIn, startNext float64) float64 {
+	level := loudness + c.intro
+	runStart := -1
+	for i, f := range frames {
+		if f.Loudness <= level {
+			runStart = -1
+			continue
+		}
+		if runStart < 0 {
+			runStart = i
+		}
+		if f.PTSTime-frames[runStart].PTSTime >= introSustainSeconds {
+			return max(cueIn, min(frames[runStart].PTSTime, startNext))
+		}
+	}
+	return cueIn
+}
+
+// calcFades derives the recommended fade durations from the frames between
+// cue-in and cue-out (inclusive). The fade-in covers the ramp from cue-in up to
+// the overlay level, so quiet intros are faded in smoothly and hard starts get
+// a minimal fade. A sustained ending is still loud at cue-out and fades out over
+// the whole overlap; otherwise the track decays by itself, and only the part
+// below the long tail level (overlay+extra) is faded out. Fades never reach
+// into the overlap (fade-in) or beyond it (fade-out).
+func (c *Calculator) calcFades(frames []Frame, loudness, cueIn, cueOut, startNext float64, sustained bool) (fadeIn, fadeOut float64) {
+	fadeIn = minFadeDuration
+	for _, f := range frames {
+		if f.Loudness > loudness+c.overlay {
+			fadeIn = max(fadeIn, f.PTSTime-cueIn)
+			break
+		}
+	}
+	fadeIn = min(fadeIn, max(minFadeDuration, startNext-cueIn))
+
+	crossDuration := cueOut - startNext
+	fadeOut = crossDuration
+	if !sustained {
+		tailStart := cueIn
+		if idx, t := firstIndexAboveFromEnd(frames, 0, len(frames), loudness+c.overlay+c.extra); idx >= 0 {
+			tailStart = t
+		}
+		fadeOut = cueOut - tailStart
+	}
+	fadeOut = min(max(fadeOut, minFadeDuration), max(crossDuration, 0))
+	return fadeIn, fadeOut
+}
+
+// classifyEnding tells how the track ends from the frames between the normal
+// overlap point (the last frame above the overlay level) and cue-out: an
+// average decay of at least coldEndSlope, or no frames in between, is a cold
+// end; a loudness holding its level for at least half of the time a
+// sustained ending; a long tail (by the longtail flag) or a fade otherwise
+// decays by itself. Unlike the sustained ending flag, which compares the
+// mean loudness of both halves, a steady fade is never taken as sustained.
+func classifyEnding(frames []Frame, longtail bool) string {
+	if len(frames) < 2 {
+		return EndingCold
+	}
+	first, last := frames[0], frames[len(frames)-1]
+	if (first.Loudness-last.Loudness)/(last.PTSTime-first.PTSTime) >= coldEndSlope {
+		return EndingCold
+	}
+
+	loudness := make([]float64, len(frames))
+	for i, f := range frames {
+		loudness[i] = f.Loudness
+	}
+	slices.Sort(loudness)
+	median := loudness[len(loudness)/2]
+	held := 0
+	for _, l := range loudness {
+		if math.Abs(l-median) <= sustainedRangeLU {
+			held++
+		}
+	}
+	switch {
+	case 2*held >= len(loudness):
+		return EndingSustained
+	case longtail:
+		return EndingLongTail
+	}
+	return EndingFade
+}
+
+// calcEnding splits elements into two equal halves (dropping the midpoint for an
+// odd count) and returns the loudness drop between them as a percentage and the
+// average momentary loudness of the trailing half. Used to detect sustained
+// endings.
+func calcEnding(elements []Frame) (lufsRatioPct, endLufs float64) {
+	l := len(elements)
+	if l < 1 {
+		return 0, 0
+	}
+	var p1, p2 []Frame
+	if l >= 2 {
+		l2 := l / 2
+		p1 = elements[:l2]
+		p2 = elements[l2+l%2:]
+	} else {
+		p1, p2 = elements, elements
+	}
+
+	var y1, y2 float64
+	for _, e := range p1 {
+		y1 += e.Loudness
+	}
+	for _, e := range p2 {
+		y2 += e.Loudness
+	}
+	y1 /= float64(len(p1))
+	y2 /= float64(len(p2))
+
+	if y2 != 0 {
+		lufsRatioPct = (1 - y1/y2) * 100.0
+	} else {
+		lufsRatioPct = (1 - math.Inf(1)) * 100.0
+	}
+	return lufsRatioPct, y2
+}
+
+// firstIndexAboveFromEnd scans frames[start:end] backwards and returns the index
+// and PTS time of the first frame whose momentary loudness exceeds level. If no
+// such frame exists it returns idx == -1. Used for cue-out and the three
+// start-next (normal/sustained/longtail) searches, which share this scan.
+func firstIndexAboveFromEnd(frames []Frame, start, end int, level float64) (idx int, ptsTime float64) {
+	for i := end - 1; i >= start; i-- {
+		if frames[i].Loudness > level {
+			return i, frames[i].PTSTime
+		}
+	}
+	return -1, 0
+}
@@ -0,0 +1,228 @@
+package cue
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AnalyzeSuite struct {
+	suite.Suite
+}
+
+func TestAnalyzeSuite(t *testing.T) {
+	suite.Run(t, &AnalyzeSuite{})
+}
+
+// synthFrames samples level every 100 ms for the given number of seconds.
+func synthFrames(seconds float64, level func(t float64) float64) []Frame {
+	var frames []Frame
+	for i := 0; float64(i)/10 < seconds; i++ {
+		t := float64(i) / 10
+		frames = append(frames, Frame{PTSTime: t, Loudness: level(t)})
+	}
+	return frames
+}
+
+// ramp returns the level at t on a linear ramp from l0 at t0 to l1 at t1.
+func ramp(t, t0, l0, t1, l1 float64) float64 {
+	return l0 + (l1-l0)*(t-t0)/(t1-t0)
+}
+
+func (s *AnalyzeSuite) TestAnalyze() {
+	// 2s of silence, a -14 LUFS body and a stepped 2s fade-out into silence
+	frames := synthFrames(62, func(t float64) float64 {
+		switch {
+		case t < 2 || t >= 60:
+			return -70
+		case t >= 59:
+			return -50
+		case t >= 58:
+			return -25
+		}
+		return -14
+	})
+	res := Analyze(frames, -14, NewTruePeakInfo(0.5, 5), DefaultCalculatorOptions())
+	s.Require().NotNil(res)
+
+	s.InDelta(62.0, res.Duration, 1e-9)
+	s.InDelta(2.0, res.CueIn, 1e-9)
+	// the body starts right away
+	s.InDelta(2.0, res.IntroEnd, 1e-9)
+	// last frame above -56 LUFS (silence -42 LU) and above -22 LUFS (overlay -8 LU)
+	s.InDelta(59.9, res.CueOut, 1e-9)
+	s.InDelta(57.9, res.CrossStartNext, 1e-9)
+	s.InDelta(2.0, res.CrossDuration, 1e-9)
+	s.False(res.LongTail)
+	s.False(res.SustainedEnding)
+	// 36 LU in 2s
+	s.Equal(EndingFade, res.Ending)
+	s.False(res.BlankSkipped)
+	s.Equal("-14.000 LUFS", res.Loudness)
+	s.Equal("5.000 LU", res.LoudnessRange)
+	s.Equal("-4.000 dB", res.Amplify)
+	s.Equal("-6.021 dBFS", res.TruePeakDb)
+
+	// clipping prevention limits the gain to reach -1 dBFS
+	opts := DefaultCalculatorOptions()
+	opts.TargetLoudness, opts.NoClip = -5, true
+	res = Analyze(frames, -14, NewTruePeakInfo(0.5, 5), opts)
+	s.Equal("5.021 dB", res.Amplify)
+	s.Equal("-3.979 dB", res.AmplifyAdjustment)
+
+	// diagnostics only go to the Log option
+	var log strings.Builder
+	opts = DefaultCalculatorOptions()
+	opts.Log = &log
+	Analyze(frames, -14, NewTruePeakInfo(0.5, 5), opts)
+	s.Contains(log.String(), "Cue out time: 59.90 s")
+}
+
+// TestAnalyzeIntro checks that a quiet intro ends where the loudness stays
+// near the integrated loudness, not on a short hit within it.
+func (s *AnalyzeSuite) TestAnalyzeIntro() {
+	frames := synthFrames(62, func(t float64) float64 {
+		switch {
+		case t < 2 || t >= 60:
+			return -70
+		case t >= 5 && t < 6:
+			return -12
+		case t < 12:
+			return -30
+		}
+		return -14
+	})
+	res := Analyze(frames, -14, NewTruePeakInfo(0.5, 5), DefaultCalculatorOptions())
+	s.InDelta(2.0, res.CueIn, 1e-9)
+	s.InDelta(12.0, res.IntroEnd, 1e-9)
+
+	// an intro above the intro level is part of the body
+	opts := DefaultCalculatorOptions()
+	opts.Intro = -20
+	res = Analyze(frames, -14, NewTruePeakInfo(0.5, 5), opts)
+	s.InDelta(2.0, res.IntroEnd, 1e-9)
+
+	// nor does a track never staying above the intro level
+	opts.Intro = 0
+	res = Analyze(frames, -14, NewTruePeakInfo(0.5, 5), opts)
+	s.InDelta(2.0, res.IntroEnd, 1e-9)
+}
+
+func (s *AnalyzeSuite) TestAnalyzeHiddenTrack() {
+	// a 10s gap before a hidden track
+	frames := synthFrames(50, func(t float64) float64 {
+		if t >= 30 && t < 40 {
+			return -70
+		}
+		return -14
+	})
+	opts := DefaultCalculatorOptions()
+	res := Analyze(frames, -14, NewTruePeakInfo(0.9, 5), opts)
+	s.InDelta(49.9, res.CueOut, 1e-9)
+	s.False(res.BlankSkipped)
+
+	opts.BlankSkip = 5
+	res = Analyze(frames, -14, NewTruePeakInfo(0.9, 5), opts)
+	s.InDelta(30.0, res.CueOut, 1e-9)
+	s.True(res.BlankSkipped)
+	s.InDelta(29.9, res.CrossStartNext, 1e-9)
+	s.Equal(EndingCold, res.Ending)
+
+	// a gap shorter than blankskip is kept
+	opts.BlankSkip = 15
+	res = Analyze(frames, -14, NewTruePeakInfo(0.9, 5), opts)
+	s.InDelta(49.9, res.CueOut, 1e-9)
+	s.False(res.BlankSkipped)
+}
+
+func (s *AnalyzeSuite) TestAnalyzeLongTail() {
+	// a 40s decay of 1.4 LU/s: overlay -22 LUFS is passed at 45.7s, so the
+	// overlap would be 24s, longer than the 15s long tail limit
+	frames := synthFrames(80, func(t float64) float64 {
+		if t > 40 {
+			return ramp(t, 40, -14, 80, -70)
+		}
+		return -14
+	})
+	res := Analyze(frames, -14, NewTruePeakInfo(0.9, 5), DefaultCalculatorOptions())
+	s.True(res.LongTail)
+	s.InDelta(69.9, res.CueOut, 1e-9)
+	// re-found at overlay+extra, -34 LUFS
+	s.InDelta(54.2, res.CrossStartNext, 1e-9)
+	s.Equal(EndingLongTail, res.Ending)
+}
+
+// TestAnalyzeEndings checks the ending classification on typical momentary
+// loudness curves.
+func (s *AnalyzeSuite) TestAnalyzeEndings() {
+	for _, tc := range []struct {
+		name  string
+		level func(t float64) float64
+		want  string
+	}{
+		// a hard stop, smeared over the 400 ms window
+		{"cold", func(t float64) float64 {
+			switch {
+			case t >= 60.3:
+				return -70
+			case t >= 60:
+				return []float64{-15.2, -17, -20}[int(math.Round((t-60)*10))]
+			}
+			return -14
+		}, EndingCold},
+		// a hard stop at the end of the file
+		{"cut", func(float64) float64 { return -14 }, EndingCold},
+		{"fade", func(t float64) float64 {
+			if t > 55 {
+				return ramp(t, 55, -14, 62, -70)
+			}
+			return -14
+		}, EndingFade},
+		// a held note below the overlay level, then a quick release
+		{"sustained", func(t float64) float64 {
+			switch {
+			case t >= 60:
+				return ramp(t, 60, -30, 61, -70)
+			case t >= 50:
+				return -30
+			}
+			return -14
+		}, EndingSustained},
+		// the same note held just above the overlay level ends cold
+		{"held cold", func(t float64) float64 {
+			switch {
+			case t >= 60:
+				return ramp(t, 60, -20, 60.5, -70)
+			case t >= 50:
+				return -20
+			}
+			return -14
+		}, EndingCold},
+	} {
+		s.Run(tc.name, func() {
+			res := Analyze(synthFrames(62, tc.level), -14, NewTruePeakInfo(0.9, 5), DefaultCalculatorOptions())
+			s.Equal(tc.want, res.Ending)
+		})
+	}
+
+	s.Equal(EndingCold, classifyEnding(nil, true))
+}
+
+func (s *AnalyzeSuite) TestAnalyzeEdgeCases() {
+	opts := DefaultCalculatorOptions()
+	s.Nil(Analyze(nil, -14, TruePeakInfo{}, opts))
+
+	// digital silence only
+	frames := synthFrames(10, func(float64) float64 { return profileLoudnessFloor })
+	res := Analyze(frames, -70, NewTruePeakInfo(0, 0), opts)
+	s.Require().NotNil(res)
+	s.Zero(res.CueIn)
+	s.Equal("-Inf dBFS", res.TruePeakDb)
+
+	// a single frame
+	res = Analyze([]Frame{{PTSTime: 0, Loudness: -14}}, -14, NewTruePeakInfo(0.9, 0), opts)
+	s.Require().NotNil(res)
+	s.InDelta(0.1, res.Duration, 1e-9)
+}
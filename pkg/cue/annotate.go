@@ -0,0 +1,101 @@
+package cue
+
+import (
+	"sort"
+	"strings"
+)
+
+const annotatePrefix = "annotate:"
+
+// escapes backslashes and double quotes inside a quoted annotate value
+var annotateEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// AnnotateURI - returns a Liquidsoap request URI of the form
+// annotate:key="value",...:uri carrying all Result annotations
+func (r *Result) AnnotateURI(uri string) (string, error) {
+	annotations, err := r.Annotations()
+	if err != nil {
+		return "", err
+	}
+	return formatAnnotate(annotations, uri), nil
+}
+
+// formatAnnotate builds an annotate: URI with the keys in sorted order and
+// every value quoted, so commas, colons and quotes in values are safe.
+func formatAnnotate(annotations map[string]string, uri string) string {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(annotatePrefix)
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(key)
+		b.WriteString(`="`)
+		b.WriteString(annotateEscaper.Replace(annotations[key]))
+		b.WriteByte('"')
+	}
+	b.WriteByte(':')
+	b.WriteString(uri)
+	return b.String()
+}
+
+// parseAnnotate splits an annotate: URI into its metadata and the annotated
+// URI; ok is false if s is not a well-formed annotate: URI. Values may be
+// quoted (with backslash escapes) or bare, as Liquidsoap accepts both.
+func parseAnnotate(s string) (meta map[string]string, uri string, ok bool) {
+	rest, found := strings.CutPrefix(s, annotatePrefix)
+	if !found {
+		return nil, "", false
+	}
+	meta = map[string]string{}
+	for {
+		key, after, found := strings.Cut(rest, "=")
+		if !found || key == "" || strings.ContainsAny(key, ",:\"") {
+			return nil, "", false
+		}
+		var val strings.Builder
+		i := 0
+		if strings.HasPrefix(after, `"`) {
+			closed := false
+			for i = 1; i < len(after); i++ {
+				if after[i] == '\\' && i+1 < len(after) {
+					i++
+					val.WriteByte(after[i])
+					continue
+				}
+				if after[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				val.WriteByte(after[i])
+			}
+			if !closed {
+				return nil, "", false
+			}
+		} else {
+			for i < len(after) && after[i] != ',' && after[i] != ':' {
+				i++
+			}
+			val.WriteString(after[:i])
+		}
+		meta[strings.TrimSpace(key)] = val.String()
+		if i >= len(after) {
+			return nil, "", false
+		}
+		switch after[i] {
+		case ',':
+			rest = after[i+1:]
+		case ':':
+			return meta, after[i+1:], true
+		default:
+			return nil, "", false
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package cue
+
+import "context"
+
+// Prober - reads the tags and the duration of an audio file
+type Prober interface {
+	// Probe returns the tags of the container and the audio stream, the
+	// latter winning on conflicts, with lower-case keys, and the duration in
+	// seconds as "duration"
+	Probe(ctx context.Context, pathToFile string) (map[string]string, error)
+}
+
+// LoudnessSource - measures the loudness of an audio file
+type LoudnessSource interface {
+	// Measure returns the momentary loudness of the file every 100 ms, along
+	// with its integrated loudness, true peak and loudness range
+	Measure(ctx context.Context, pathToFile string) (*Measurement, error)
+}
+
+// Measurement - the loudness of a whole audio file, the input of Analyze
+type Measurement struct {
+	Frames []Frame
+	// Integrated is the integrated loudness in LUFS
+	Integrated float64
+	TruePeak   TruePeakInfo
+	// Onsets is the onset strength for the beat detection, OnsetRate values
+	// per second; nil if the source does not detect onsets
+	Onsets []float64
+}
@@ -0,0 +1,96 @@
+package cue
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeBackend - a Prober and LoudnessSource serving fixed results
+type fakeBackend struct {
+	tags        map[string]string
+	measurement *Measurement
+	err         error
+	probes      int
+	measures    int
+}
+
+func (f *fakeBackend) Probe(ctx context.Context, _ string) (map[string]string, error) {
+	f.probes++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return maps.Clone(f.tags), ctx.Err()
+}
+
+func (f *fakeBackend) Measure(ctx context.Context, _ string) (*Measurement, error) {
+	f.measures++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.measurement, ctx.Err()
+}
+
+type BackendSuite struct {
+	suite.Suite
+}
+
+func TestBackendSuite(t *testing.T) {
+	suite.Run(t, &BackendSuite{})
+}
+
+func (s *BackendSuite) fakeCalculator(fake *fakeBackend) *Calculator {
+	opts := DefaultCalculatorOptions()
+	opts.Prober, opts.LoudnessSource = fake, fake
+	return NewCalculator(&opts)
+}
+
+func (s *BackendSuite) TestCalc() {
+	frames := synthFrames(60, func(t float64) float64 {
+		if t < 1 || t >= 58 {
+			return -70
+		}
+		return -14
+	})
+	fake := &fakeBackend{
+		tags:        map[string]string{"duration": "60.0", "title": "Fake"},
+		measurement: &Measurement{Frames: frames, Integrated: -14, TruePeak: NewTruePeakInfo(0.9, 3)},
+	}
+	c := s.fakeCalculator(fake)
+
+	res, err := c.Calc("a.flac")
+	s.Require().NoError(err)
+	s.False(res.Cached())
+	s.Equal(Analyze(frames, -14, NewTruePeakInfo(0.9, 3), DefaultCalculatorOptions()), res)
+	s.Equal(1, fake.probes)
+	s.Equal(1, fake.measures)
+
+	// complete tags are used as they are
+	tags, err := cachedTags(res)
+	s.Require().NoError(err)
+	tags["liq_gocue_params"] = c.paramsFingerprint()
+	tags["duration"] = "60.0"
+	fake.tags = tags
+	cached, err := c.Calc("a.flac")
+	s.Require().NoError(err)
+	s.True(cached.Cached())
+	s.Equal(res.CueOut, cached.CueOut)
+	s.Equal(2, fake.probes)
+	s.Equal(1, fake.measures)
+}
+
+func (s *BackendSuite) TestCalcErrors() {
+	fake := &fakeBackend{err: errors.New("no backend")}
+	c := s.fakeCalculator(fake)
+	_, err := c.Calc("a.flac")
+	s.ErrorIs(err, fake.err)
+	s.Zero(fake.measures)
+
+	// the analysis needs at least one frame
+	fake = &fakeBackend{tags: map[string]string{"duration": "1.0"}, measurement: &Measurement{}}
+	_, err = s.fakeCalculator(fake).Calc("a.flac")
+	s.ErrorContains(err, "no audio frames")
+}
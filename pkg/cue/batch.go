@@ -0,0 +1,152 @@
+package cue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BatchResult - outcome of analysing a single file of a batch
+type BatchResult struct {
+	// Index is the position of Path in the input sequence
+	Index  int
+	Path   string
+	Result *Result
+	Err    error
+}
+
+// MarshalJSON - returns the Result fields flattened next to "path" and, if the
+// analysis failed, "error"
+func (b BatchResult) MarshalJSON() ([]byte, error) {
+	head := struct {
+		Path  string `json:"path"`
+		Error string `json:"error,omitempty"`
+	}{Path: b.Path}
+	if b.Err != nil {
+		head.Error = b.Err.Error()
+	}
+	out, err := json.Marshal(head)
+	if err != nil || b.Result == nil {
+		return out, err
+	}
+	body, err := b.Result.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	// splice {"path":...} and {"duration":...} into a single object
+	out = bytes.TrimSuffix(out, []byte("}"))
+	return append(append(out, ','), bytes.TrimPrefix(body, []byte("{"))...), nil
+}
+
+// MarshalYAML - returns a YAML document with "path", "error" (if the analysis
+// failed) and the Result fields
+func (b BatchResult) MarshalYAML() ([]byte, error) {
+	head := struct {
+		Path  string `yaml:"path"`
+		Error string `yaml:"error,omitempty"`
+	}{Path: b.Path}
+	if b.Err != nil {
+		head.Error = b.Err.Error()
+	}
+	out, err := yaml.Marshal(head)
+	if err != nil || b.Result == nil {
+		return out, err
+	}
+	body, err := b.Result.MarshalYAML()
+	if err != nil {
+		return nil, err
+	}
+	return append(out, body...), nil
+}
+
+// BatchJob - a single file of a batch, with optional metadata as for CalcWithMetadata
+type BatchJob struct {
+	Path     string
+	Metadata map[string]string
+}
+
+// CalcBatch - analyses every path received from paths with at most workers
+// concurrent Calc calls. Results are delivered in completion order; the
+// returned channel is closed once paths is closed and all files are done.
+func (c *Calculator) CalcBatch(paths <-chan string, workers int) <-chan BatchResult {
+	return c.CalcBatchContext(context.Background(), paths, workers)
+}
+
+// CalcBatchContext - like CalcBatch, but once ctx is done no further paths are
+// read, running analyses are aborted as by CalcContext, and the returned
+// channel is closed when they have finished
+func (c *Calculator) CalcBatchContext(ctx context.Context, paths <-chan string, workers int) <-chan BatchResult {
+	jobs := make(chan BatchJob)
+	go func() {
+		defer close(jobs)
+		for {
+			select {
+			case p, ok := <-paths:
+				if !ok {
+					return
+				}
+				select {
+				case jobs <- BatchJob{Path: p}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c.CalcJobsContext(ctx, jobs, workers)
+}
+
+// CalcJobs - like CalcBatch, but every job carries its own metadata
+func (c *Calculator) CalcJobs(jobs <-chan BatchJob, workers int) <-chan BatchResult {
+	return c.CalcJobsContext(context.Background(), jobs, workers)
+}
+
+// CalcJobsContext - CalcJobs with the cancellation of CalcBatchContext
+func (c *Calculator) CalcJobsContext(ctx context.Context, jobs <-chan BatchJob, workers int) <-chan BatchResult {
+	type indexedJob struct {
+		BatchJob
+		index int
+	}
+	workers = max(workers, 1)
+	queue := make(chan indexedJob)
+	results := make(chan BatchResult, workers)
+
+	go func() {
+		defer close(queue)
+		for i := 0; ; i++ {
+			select {
+			case job, ok := <-jobs:
+				if !ok {
+					return
+				}
+				select {
+				case queue <- indexedJob{BatchJob: job, index: i}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for job := range queue {
+				res, err := c.CalcWithMetadataContext(ctx, job.Path, job.Metadata)
+				results <- BatchResult{Index: job.index, Path: job.Path, Result: res, Err: err}
+			}
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
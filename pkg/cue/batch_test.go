@@ -0,0 +1,74 @@
+package cue
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BatchSuite struct {
+	suite.Suite
+}
+
+func TestBatchSuite(t *testing.T) {
+	suite.Run(t, &BatchSuite{})
+}
+
+func (s *BatchSuite) TestMarshalJSON() {
+	out, err := BatchResult{Path: "a.mp3", Err: errors.New("boom")}.MarshalJSON()
+	s.Require().NoError(err)
+	s.JSONEq(`{"path":"a.mp3","error":"boom"}`, string(out))
+
+	out, err = BatchResult{Path: "b.mp3", Result: &Result{CueIn: 1.5}}.MarshalJSON()
+	s.Require().NoError(err)
+	s.Contains(string(out), `{"path":"b.mp3","duration":0,`)
+	s.Contains(string(out), `"liq_cue_in":1.5,`)
+}
+
+func (s *BatchSuite) TestCalcBatch() {
+	paths := make(chan string)
+	go func() {
+		defer close(paths)
+		for _, p := range []string{"missing1.mp3", "missing2.mp3", "missing3.mp3"} {
+			paths <- p
+		}
+	}()
+
+	var got []int
+	for res := range NewCalculator(nil).CalcBatch(paths, 2) {
+		s.Error(res.Err)
+		s.Nil(res.Result)
+		got = append(got, res.Index)
+	}
+	slices.Sort(got)
+	s.Equal([]int{0, 1, 2}, got)
+}
+
+func (s *BatchSuite) TestCalcBatchContext() {
+	// paths is never closed, so only the cancellation ends the batch
+	paths := make(chan string)
+	ctx, cancel := context.WithCancel(s.T().Context())
+	results := NewCalculator(nil).CalcBatchContext(ctx, paths, 2)
+	paths <- "missing1.mp3"
+	res := <-results
+	s.Error(res.Err)
+
+	cancel()
+	for res := range results {
+		s.ErrorIs(res.Err, context.Canceled)
+	}
+}
+
+func (s *BatchSuite) TestMarshalYAML() {
+	out, err := BatchResult{Path: "a.mp3", Err: errors.New("boom")}.MarshalYAML()
+	s.Require().NoError(err)
+	s.Equal("path: a.mp3\nerror: boom\n", string(out))
+
+	out, err = BatchResult{Path: "b.mp3", Result: &Result{CueIn: 1.5}}.MarshalYAML()
+	s.Require().NoError(err)
+	s.Contains(string(out), "path: b.mp3\nduration: 0\n")
+	s.Contains(string(out), "\nliq_cue_in: 1.5\n")
+}
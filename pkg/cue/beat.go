@@ -0,0 +1,370 @@
+package cue
+
+import (
+	"context"
+	"math"
+	"math/cmplx"
+	"slices"
+)
+
+const (
+	// OnsetRate - onset strength values per second
+	OnsetRate = 100
+	// sample rate ffmpeg decodes to for the onset detection
+	onsetSampleRate = 22050
+	// band edges of the onset detection: kick drums and bass below the low
+	// edge, hi-hats and cymbals above the high one
+	onsetLowHz  = 200.0
+	onsetHighHz = 4000.0
+	// gain before the logarithmic compression of band energies, so that
+	// quiet passages below about -50 dBFS hardly produce onsets
+	onsetCompression = 1e5
+	// tempo range of the beat detection, in BPM; tempi outside are found at
+	// twice or half their value
+	minBPM = 60.0
+	maxBPM = 200.0
+	// the tempo prior: a log-normal weight around preferredBPM with a
+	// standard deviation of tempoSpreadOctaves, against octave errors
+	preferredBPM       = 120.0
+	tempoSpreadOctaves = 1.0
+	// shortest input with a detectable beat, in seconds
+	minBeatSeconds = 10.0
+	// least share of the onset strength falling on the beats, as measured by
+	// the Fourier coefficient at the beat frequency; below, the track has no
+	// steady beat (e.g. speech or ambient music). Drums score about 0.4 to
+	// 0.5, noise less than 0.1 except for short inputs.
+	minBeatStrength = 0.2
+	// beats per bar; the downbeat detection assumes 4/4 time
+	beatsPerBar = 4
+)
+
+// SnapTo - a unit of the beat grid to snap cue points to
+type SnapTo uint
+
+const (
+	// SnapOff - leave the cue points as analysed
+	SnapOff SnapTo = iota
+	// SnapBeat - snap to the nearest beat
+	SnapBeat
+	// SnapBar - snap to the nearest downbeat, the first beat of a bar
+	SnapBar
+)
+
+func (s SnapTo) String() string {
+	switch s {
+	case SnapBeat:
+		return "beat"
+	case SnapBar:
+		return "bar"
+	}
+	return "off"
+}
+
+// OnsetSource - detects the note onsets of an audio file, for the beat
+// analysis of LoudnessSources not delivering Measurement.Onsets
+type OnsetSource interface {
+	// Onsets returns the onset strength of the file, OnsetRate values per
+	// second
+	Onsets(ctx context.Context, pathToFile string) ([]float64, error)
+}
+
+// BeatGrid - a constant tempo beat grid in 4/4 time
+type BeatGrid struct {
+	// BPM is the tempo in beats per minute, 0 if the track has no steady beat
+	BPM float64
+	// FirstBeat is the time of the first beat in seconds, less than one beat
+	// from the start
+	FirstBeat float64
+	// FirstDownbeat is the time of the first beat of a bar in seconds, less
+	// than one bar from the start
+	FirstDownbeat float64
+}
+
+// Beats - returns the times of the beats from from to to (inclusive)
+func (g BeatGrid) Beats(from, to float64) []float64 {
+	return g.points(g.FirstBeat, 60/g.BPM, from, to)
+}
+
+// Downbeats - returns the times of the downbeats from from to to (inclusive)
+func (g BeatGrid) Downbeats(from, to float64) []float64 {
+	return g.points(g.FirstDownbeat, beatsPerBar*60/g.BPM, from, to)
+}
+
+func (g BeatGrid) points(first, period, from, to float64) []float64 {
+	if g.BPM <= 0 {
+		return nil
+	}
+	var res []float64
+	for k := math.Ceil((from - first) / period); first+k*period <= to; k++ {
+		res = append(res, first+k*period)
+	}
+	return res
+}
+
+// grid returns the first point and the period of the grid unit.
+func (g BeatGrid) grid(unit SnapTo) (first, period float64) {
+	if unit == SnapBar {
+		return g.FirstDownbeat, beatsPerBar * 60 / g.BPM
+	}
+	return g.FirstBeat, 60 / g.BPM
+}
+
+// nearest returns the grid point of the unit nearest to t.
+func (g BeatGrid) nearest(t float64, unit SnapTo) float64 {
+	first, period := g.grid(unit)
+	return first + math.Round((t-first)/period)*period
+}
+
+// previous returns the last grid point of the unit at or before t.
+func (g BeatGrid) previous(t float64, unit SnapTo) float64 {
+	first, period := g.grid(unit)
+	return first + math.Floor((t-first)/period)*period
+}
+
+// next returns the first grid point of the unit at or after t.
+func (g BeatGrid) next(t float64, unit SnapTo) float64 {
+	first, period := g.grid(unit)
+	return first + math.Ceil((t-first)/period)*period
+}
+
+// onsetDetector - computes the onset strength of interleaved samples: the
+// increase of the logarithmically compressed energy of three frequency bands
+// from one 10 ms hop to the next, summed over the bands
+type onsetDetector struct {
+	sampleRate int
+	channels   int
+	// sample frames written so far; hops end at whole sample frames counted
+	// from the start, so they do not drift for rates not divisible by 100
+	samples int64
+	// low-pass and high-pass filters splitting the mono mix into bands
+	low, high           biquad
+	lowState, highState biquadState
+	partial             []float64
+	// samples and band energies of the current hop, band log energies of the
+	// previous one
+	pos    int
+	energy [3]float64
+	prev   [3]float64
+	onsets []float64
+}
+
+func newOnsetDetector(sampleRate, channels int) *onsetDetector {
+	return &onsetDetector{
+		sampleRate: sampleRate,
+		channels:   channels,
+		low:        lowPass(sampleRate, onsetLowHz),
+		high:       highPass(sampleRate, onsetHighHz),
+	}
+}
+
+// Write - detects onsets in interleaved samples; the samples of one sample
+// frame may be split across calls
+func (d *onsetDetector) Write(samples []float64) {
+	if len(d.partial) > 0 {
+		n := min(d.channels-len(d.partial), len(samples))
+		d.partial = append(d.partial, samples[:n]...)
+		samples = samples[n:]
+		if len(d.partial) < d.channels {
+			return
+		}
+		d.writeFrame(d.partial)
+		d.partial = d.partial[:0]
+	}
+	for len(samples) >= d.channels {
+		d.writeFrame(samples[:d.channels])
+		samples = samples[d.channels:]
+	}
+	d.partial = append(d.partial, samples...)
+}
+
+func (d *onsetDetector) writeFrame(frame []float64) {
+	var x float64
+	for _, s := range frame {
+		x += s
+	}
+	x /= float64(d.channels)
+	low := d.low.process(&d.lowState, x)
+	high := d.high.process(&d.highState, x)
+	mid := x - low - high
+	d.energy[0] += low * low
+	d.energy[1] += mid * mid
+	d.energy[2] += high * high
+	d.pos++
+	d.samples++
+	if d.samples < int64(len(d.onsets)+1)*int64(d.sampleRate)/OnsetRate {
+		return
+	}
+
+	var strength float64
+	for band, e := range d.energy {
+		l := math.Log1p(onsetCompression * e / float64(d.pos))
+		// the first hop has no predecessor to rise from
+		if len(d.onsets) > 0 {
+			strength += max(0, l-d.prev[band])
+		}
+		d.prev[band] = l
+	}
+	d.onsets = append(d.onsets, strength)
+	d.pos, d.energy = 0, [3]float64{}
+}
+
+// Onsets - returns the onset strength of all complete hops written so far
+func (d *onsetDetector) Onsets() []float64 {
+	return d.onsets
+}
+
+// lowPass returns a second order Butterworth low-pass filter.
+func lowPass(sampleRate int, cutoff float64) biquad {
+	k := math.Tan(math.Pi * min(cutoff, 0.45*float64(sampleRate)) / float64(sampleRate))
+	a0 := 1 + math.Sqrt2*k + k*k
+	return biquad{
+		b0: k * k / a0,
+		b1: 2 * k * k / a0,
+		b2: k * k / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - math.Sqrt2*k + k*k) / a0,
+	}
+}
+
+// highPass returns a second order Butterworth high-pass filter.
+func highPass(sampleRate int, cutoff float64) biquad {
+	k := math.Tan(math.Pi * min(cutoff, 0.45*float64(sampleRate)) / float64(sampleRate))
+	a0 := 1 + math.Sqrt2*k + k*k
+	return biquad{
+		b0: 1 / a0,
+		b1: -2 / a0,
+		b2: 1 / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - math.Sqrt2*k + k*k) / a0,
+	}
+}
+
+// DetectBeats - estimates a constant tempo beat grid from the onset strength
+// of a track (OnsetRate values per second, see Measurement.Onsets): the
+// tempo from the autocorrelation of the onsets, weighted towards 120 BPM
+// against octave errors, then refined together with the phase of the beats
+// to the frequency at which the onsets are most regular; the downbeats are
+// the beats of the 4/4 bar position with the strongest onsets. Tracks shorter
+// than 10 seconds or without a steady beat return a zero BPM.
+func DetectBeats(onsets []float64) BeatGrid {
+	if len(onsets) < minBeatSeconds*OnsetRate {
+		return BeatGrid{}
+	}
+	env := onsetEnvelope(onsets)
+
+	// coarse tempo: the best weighted autocorrelation lag
+	minLag := 60 * OnsetRate / maxBPM
+	maxLag := 60 * OnsetRate / minBPM
+	bestLag, bestScore := 0, 0.0
+	for lag := int(math.Floor(minLag)); lag <= int(math.Ceil(maxLag)); lag++ {
+		var ac float64
+		for i := lag; i < len(env); i++ {
+			ac += env[i] * env[i-lag]
+		}
+		if ac *= tempoWeight(float64(lag)); ac > bestScore {
+			bestLag, bestScore = lag, ac
+		}
+	}
+	if bestLag == 0 {
+		return BeatGrid{}
+	}
+
+	// fine tempo and phase: the Fourier coefficient of the onsets at the beat
+	// frequency collects the onset strength of a comb of beats, its argument
+	// tells where they are. The periods are stepped by half the width of its
+	// peak, which narrows with the track length, around the coarse lag and
+	// its half and double, which integer lags may have missed.
+	var total float64
+	for _, v := range env {
+		total += v
+	}
+	if total == 0 {
+		return BeatGrid{}
+	}
+	best := func(lo, hi, step float64) (period float64, beats complex128) {
+		for p := lo; p <= hi; p += step {
+			if c := beatPhasor(env, p); cmplx.Abs(c) > cmplx.Abs(beats) {
+				period, beats = p, c
+			}
+		}
+		return period, beats
+	}
+	var period, score float64
+	var beats complex128
+	for _, lag := range []float64{float64(bestLag) / 2, float64(bestLag), float64(bestLag) * 2} {
+		if lag < minLag-1 || lag > maxLag+1 {
+			continue
+		}
+		step := min(0.05, lag*lag/float64(2*len(env)))
+		p, c := best(lag-1, lag+1, step)
+		p, c = best(p-step, p+step, step/10)
+		if s := cmplx.Abs(c) * tempoWeight(p); s > score {
+			period, beats, score = p, c, s
+		}
+	}
+	if cmplx.Abs(beats) < minBeatStrength*total {
+		return BeatGrid{}
+	}
+	phase := math.Mod(-cmplx.Phase(beats)/(2*math.Pi)*period+period, period)
+
+	// downbeats: the bar position of the strongest beats
+	var bars [beatsPerBar]float64
+	for k := 0; ; k++ {
+		i := int(math.Round(phase + float64(k)*period))
+		if i >= len(env) {
+			break
+		}
+		bars[k%beatsPerBar] += slices.Max(env[max(0, i-1):min(len(env), i+2)])
+	}
+	downbeat := 0
+	for k, v := range bars {
+		if v > bars[downbeat] {
+			downbeat = k
+		}
+	}
+	return BeatGrid{
+		BPM:           60 * OnsetRate / period,
+		FirstBeat:     phase / OnsetRate,
+		FirstDownbeat: (phase + float64(downbeat)*period) / OnsetRate,
+	}
+}
+
+// tempoWeight returns the prior of a beat period in hops.
+func tempoWeight(period float64) float64 {
+	octaves := math.Log2(period / (60 * OnsetRate / preferredBPM))
+	return math.Exp(-0.5 * octaves * octaves / (tempoSpreadOctaves * tempoSpreadOctaves))
+}
+
+// onsetEnvelope prepares the onset strength for the beat detection: the
+// local mean over half a second either side is subtracted, keeping only the
+// peaks.
+func onsetEnvelope(onsets []float64) []float64 {
+	const radius = OnsetRate / 2
+	sums := make([]float64, len(onsets)+1)
+	for i, v := range onsets {
+		sums[i+1] = sums[i] + v
+	}
+	env := make([]float64, len(onsets))
+	for i, v := range onsets {
+		lo, hi := max(0, i-radius), min(len(onsets), i+radius+1)
+		env[i] = max(0, v-(sums[hi]-sums[lo])/float64(hi-lo))
+	}
+	return env
+}
+
+// beatPhasor returns the Fourier coefficient of env at the frequency of one
+// beat per period hops.
+func beatPhasor(env []float64, period float64) complex128 {
+	rot := cmplx.Rect(1, -2*math.Pi/period)
+	z := complex(1, 0)
+	var sum complex128
+	for i, v := range env {
+		sum += complex(v, 0) * z
+		z *= rot
+		// against the rounding errors of the repeated rotation
+		if i%1024 == 1023 {
+			z /= complex(cmplx.Abs(z), 0)
+		}
+	}
+	return sum
+}
@@ -0,0 +1,223 @@
+package cue
+
+import (
+	"math"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BeatSuite struct {
+	suite.Suite
+}
+
+func TestBeatSuite(t *testing.T) {
+	suite.Run(t, &BeatSuite{})
+}
+
+// clickTrack returns mono samples of drum-like clicks, a decaying 60 Hz tone
+// plus a noise burst, at the given tempo from offset seconds on; with accent,
+// the first beat of every bar is twice as loud.
+func clickTrack(sampleRate int, seconds, bpm, offset float64, accent bool) []float64 {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	samples := make([]float64, int(seconds*float64(sampleRate)))
+	period := 60 / bpm
+	for i := range samples {
+		t := float64(i) / float64(sampleRate)
+		samples[i] = 0.01 * (rnd.Float64()*2 - 1)
+		if t < offset {
+			continue
+		}
+		k := math.Floor((t - offset) / period)
+		dt := t - offset - k*period
+		amp := 0.3
+		if accent && int(k)%beatsPerBar == 0 {
+			amp = 0.6
+		}
+		samples[i] += amp * math.Exp(-dt*30) * math.Sin(2*math.Pi*60*dt)
+		samples[i] += amp / 3 * math.Exp(-dt*200) * (rnd.Float64()*2 - 1)
+	}
+	return samples
+}
+
+func onsetsOf(sampleRate int, samples []float64) []float64 {
+	d := newOnsetDetector(sampleRate, 1)
+	d.Write(samples)
+	return d.Onsets()
+}
+
+func (s *BeatSuite) TestDetectBeats() {
+	tests := []struct {
+		name   string
+		bpm    float64
+		offset float64
+		accent bool
+	}{
+		{"house", 128, 0.25, true},
+		{"hip hop", 93.7, 1.013, true},
+		// found at 174, not at half the tempo the prior prefers
+		{"drum and bass", 174, 0.1, false},
+	}
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			g := DetectBeats(onsetsOf(44100, clickTrack(44100, 60, tt.bpm, tt.offset, tt.accent)))
+			s.InDelta(tt.bpm, g.BPM, 0.05)
+			period := 60 / tt.bpm
+			s.InDelta(math.Mod(tt.offset, period), g.FirstBeat, 0.015)
+			if tt.accent {
+				s.InDelta(math.Mod(tt.offset, beatsPerBar*period), g.FirstDownbeat, 0.015)
+			}
+		})
+	}
+
+	// hops stay 10 ms long for rates not divisible by 100
+	g := DetectBeats(onsetsOf(11025, clickTrack(11025, 60, 128, 0.25, true)))
+	s.InDelta(128, g.BPM, 0.05)
+
+	// noise, and too short inputs, have no beat
+	rnd := rand.New(rand.NewPCG(3, 4))
+	noise := make([]float64, 44100*60)
+	for i := range noise {
+		noise[i] = 0.2 * (rnd.Float64()*2 - 1) * (1 + math.Sin(float64(i)/44100*0.7))
+	}
+	s.Zero(DetectBeats(onsetsOf(44100, noise)).BPM)
+	s.Zero(DetectBeats(onsetsOf(44100, clickTrack(44100, 5, 120, 0, true))).BPM)
+	s.Zero(DetectBeats(make([]float64, 3000)).BPM)
+}
+
+func (s *BeatSuite) TestGrid() {
+	g := BeatGrid{BPM: 120, FirstBeat: 0.2, FirstDownbeat: 1.2}
+	s.InDeltaSlice([]float64{0.2, 0.7, 1.2, 1.7}, g.Beats(0, 2), 1e-9)
+	s.InDeltaSlice([]float64{1.2, 3.2}, g.Downbeats(0, 5), 1e-9)
+	s.InDelta(3.2, g.nearest(3.0, SnapBar), 1e-9)
+	s.InDelta(1.2, g.previous(3.0, SnapBar), 1e-9)
+	s.InDelta(2.7, g.nearest(2.9, SnapBeat), 1e-9)
+	s.InDelta(3.2, g.previous(3.2, SnapBeat), 1e-9)
+	s.InDelta(3.2, g.next(1.3, SnapBar), 1e-9)
+	s.InDelta(0.2, g.next(0, SnapBeat), 1e-9)
+	s.Nil(BeatGrid{}.Beats(0, 10))
+}
+
+func (s *BeatSuite) TestSnap() {
+	p := testProfile(240)
+	p.beats = &BeatGrid{BPM: 120, FirstBeat: 0.2, FirstDownbeat: 1.2}
+	s.Zero(NewCalculator(nil).analyze(p).BPM)
+	opts := DefaultCalculatorOptions()
+	opts.Beats = true
+	raw := NewCalculator(&opts).analyze(p)
+	s.InDelta(120, raw.BPM, 1e-9)
+	s.Require().NotNil(raw.FirstDownbeat)
+	s.InDelta(1.2, *raw.FirstDownbeat, 1e-9)
+	s.Nil(raw.CueInRaw)
+	s.Nil(raw.CrossStartNextRaw)
+
+	opts.Snap = SnapBar
+	res := NewCalculator(&opts).analyze(p)
+	s.Equal(raw.CueIn, res.CueIn)
+	s.Equal(new(raw.CrossStartNext), res.CrossStartNextRaw)
+	s.InDelta(raw.CrossStartNext, res.CrossStartNext, 1)
+	s.InDelta(0, math.Remainder(res.CrossStartNext-1.2, 2), 1e-9)
+	s.InDelta(res.CueOut-res.CrossStartNext, res.CrossDuration, 1e-9)
+
+	opts.Snap, opts.SnapCueIn = SnapBeat, true
+	res = NewCalculator(&opts).analyze(p)
+	s.Equal(new(raw.CueIn), res.CueInRaw)
+	s.LessOrEqual(res.CueIn, raw.CueIn)
+	s.InDelta(0, math.Remainder(res.CueIn-0.2, 0.5), 1e-9)
+	s.InDelta(0, math.Remainder(res.CrossStartNext-0.2, 0.5), 1e-9)
+	s.InDelta(res.CueOut-res.CueIn, res.CueDuration, 1e-9)
+
+	// without a grid point before the cue-in, the first one is taken
+	opts.Snap = SnapBar
+	res = NewCalculator(&opts).analyze(p)
+	s.Less(raw.CueIn, 1.2)
+	s.InDelta(1.2, res.CueIn, 1e-9)
+
+	// on its own, SnapCueIn snaps to beats
+	opts.Snap = SnapOff
+	res = NewCalculator(&opts).analyze(p)
+	s.Equal(new(raw.CueIn), res.CueInRaw)
+	s.InDelta(0, math.Remainder(res.CueIn-0.2, 0.5), 1e-9)
+	s.InDelta(0, math.Remainder(res.CrossStartNext-0.2, 0.5), 1e-9)
+	s.Contains(NewCalculator(&opts).paramsFingerprint(), "snap=beat snapcuein=true")
+
+	// without a steady beat, nothing is moved
+	opts.Snap = SnapBeat
+	p.beats = &BeatGrid{}
+	res = NewCalculator(&opts).analyze(p)
+	s.Equal(raw.CueIn, res.CueIn)
+	s.Equal(raw.CrossStartNext, res.CrossStartNext)
+	s.Equal(new(raw.CrossStartNext), res.CrossStartNextRaw)
+	s.Zero(res.BPM)
+	s.Nil(res.FirstBeat)
+}
+
+// TestZeroTimes checks that beat grid and raw cue-in times of 0 s, common for
+// tracks without leading silence, are reported.
+func (s *BeatSuite) TestZeroTimes() {
+	p := testProfile(240)
+	for i := range 10 {
+		p.frames[i].Loudness = -14
+	}
+	p.beats = &BeatGrid{BPM: 120, FirstBeat: 0, FirstDownbeat: 0}
+	opts := DefaultCalculatorOptions()
+	opts.Snap = SnapBar
+	res := NewCalculator(&opts).analyze(p)
+	s.Equal(new(0.0), res.CueInRaw)
+	s.Equal(new(0.0), res.FirstBeat)
+
+	a, err := res.Annotations()
+	s.Require().NoError(err)
+	s.Equal("0.000", a["liq_cue_in_raw"])
+	s.Equal("0.000", a["liq_first_beat"])
+	s.Equal("0.000", a["liq_first_downbeat"])
+	row := res.Row()
+	s.Equal("0.000", row[slices.Index(ResultColumns(), "liq_cue_in_raw")])
+
+	// and read back from tags
+	cached := parseTags(a)
+	s.Equal(res.CueInRaw, cached.CueInRaw)
+	s.Equal(res.FirstDownbeat, cached.FirstDownbeat)
+	s.Nil(parseTags(map[string]string{}).CueInRaw)
+}
+
+// TestCalc checks the beat detection of a natively decoded file, and that
+// the beat settings are part of the parameter fingerprint.
+func (s *BeatSuite) TestCalc() {
+	dir := s.T().TempDir()
+	path := filepath.Join(dir, "a.wav")
+	s.Require().NoError(writePCMWAV(path, 44100, 1, sampleFormat{size: 2}, false, clickTrack(44100, 30, 128, 0.5, true)))
+
+	opts := DefaultCalculatorOptions()
+	opts.FFmpeg = FFmpeg{FFmpegPath: filepath.Join(dir, "missing"), FFprobePath: filepath.Join(dir, "missing")}
+	opts.Snap = SnapBeat
+	opts.Sidecar = true
+	c := NewCalculator(&opts)
+	res, err := c.Calc(path)
+	s.Require().NoError(err)
+	s.InDelta(128, res.BPM, 0.05)
+	s.Require().NotNil(res.FirstDownbeat)
+	s.InDelta(0.5, *res.FirstDownbeat, 0.015)
+	s.NotNil(res.CrossStartNextRaw)
+	s.Contains(c.paramsFingerprint(), "beats=true snap=beat snapcuein=false")
+	s.NotContains(NewCalculator(nil).paramsFingerprint(), "beats")
+
+	res, err = c.Calc(path)
+	s.Require().NoError(err)
+	s.True(res.Cached())
+	s.InDelta(128, res.BPM, 0.05)
+
+	// a loudness source without onsets cannot detect beats
+	fake := &fakeBackend{measurement: &Measurement{
+		Frames:     synthFrames(60, func(float64) float64 { return -14 }),
+		Integrated: -14,
+	}}
+	opts = DefaultCalculatorOptions()
+	opts.Prober, opts.LoudnessSource = fake, fake
+	opts.Beats = true
+	_, err = NewCalculator(&opts).Calc(path)
+	s.ErrorContains(err, "detects no onsets")
+}
@@ -0,0 +1,234 @@
+package cue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// how long to wait for another process to release the cache database
+const cacheLockTimeout = 10 * time.Second
+
+var (
+	// absolute file path -> cachedFile
+	cacheFilesBucket = []byte("files")
+	// content hash -> cachedResult
+	cacheResultsBucket = []byte("results")
+	// content hash -> encoded loudness profile
+	cacheProfilesBucket = []byte("profiles")
+)
+
+// Cache - an embedded database of analysis results, keyed by a hash of the
+// audio content, so that a file is analysed once even if it is renamed,
+// moved or retagged. The database file is only locked while it is accessed,
+// so several gocue processes can share it. It is safe for concurrent use.
+type Cache struct {
+	path  string
+	mu    sync.Mutex
+	db    *bolt.DB
+	users int
+}
+
+// cachedFile - the content hash of a file, valid while its size and
+// modification time are unchanged
+type cachedFile struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // Unix nanoseconds
+	Hash    string `json:"hash"`
+}
+
+// cachedResult - an analysis result, the fingerprint of the settings it was
+// computed with and the version of gocue that did
+type cachedResult struct {
+	Params  string  `json:"params"`
+	Version string  `json:"version,omitempty"`
+	Result  *Result `json:"result"`
+}
+
+// OpenCache - opens the cache database at path, creating it if needed
+func OpenCache(path string) (*Cache, error) {
+	c := &Cache{path: path}
+	err := c.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{cacheFilesBucket, cacheResultsBucket, cacheProfilesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open cache %q: %w", path, err)
+	}
+	return c, nil
+}
+
+// acquire opens the database unless another goroutine already has.
+func (c *Cache) acquire() (*bolt.DB, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		db, err := bolt.Open(c.path, 0o644, &bolt.Options{Timeout: cacheLockTimeout})
+		if err != nil {
+			return nil, err
+		}
+		c.db = db
+	}
+	c.users++
+	return c.db, nil
+}
+
+// release closes the database once no goroutine uses it any more, so other
+// processes can lock it.
+func (c *Cache) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.users--; c.users == 0 {
+		_ = c.db.Close()
+		c.db = nil
+	}
+}
+
+func (c *Cache) view(fn func(tx *bolt.Tx) error) error {
+	db, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	defer c.release()
+	return db.View(fn)
+}
+
+func (c *Cache) update(fn func(tx *bolt.Tx) error) error {
+	db, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	defer c.release()
+	return db.Update(fn)
+}
+
+// hash returns the absolute path and the content hash of pathToFile; the
+// hash is only computed if the file is new or has changed since. The file
+// record is returned if it has to be stored.
+func (c *Cache) hash(pathToFile string) (abs, hash string, rec *cachedFile, err error) {
+	if abs, err = filepath.Abs(pathToFile); err != nil {
+		return "", "", nil, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", "", nil, err
+	}
+	var known cachedFile
+	err = c.view(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(cacheFilesBucket).Get([]byte(abs)); raw != nil {
+			return json.Unmarshal(raw, &known)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", nil, err
+	}
+	if known.Hash != "" && known.Size == info.Size() && known.ModTime == info.ModTime().UnixNano() {
+		return abs, known.Hash, nil, nil
+	}
+	if hash, err = contentHash(abs); err != nil {
+		return "", "", nil, err
+	}
+	return abs, hash, &cachedFile{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Hash: hash}, nil
+}
+
+// lookup returns the result stored for the audio content of pathToFile and
+// the fingerprint of the settings it was computed with.
+func (c *Cache) lookup(pathToFile string) (res *Result, params string, err error) {
+	abs, hash, rec, err := c.hash(pathToFile)
+	if err != nil {
+		return nil, "", err
+	}
+	if rec != nil {
+		// a new, moved or retagged file: remember its hash, for store after
+		// an analysis as well as for the next time
+		if err := c.put(cacheFilesBucket, abs, rec); err != nil {
+			return nil, "", err
+		}
+	}
+	var cached cachedResult
+	err = c.view(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(cacheResultsBucket).Get([]byte(hash)); raw != nil {
+			return json.Unmarshal(raw, &cached)
+		}
+		return nil
+	})
+	if err != nil || cached.Result == nil {
+		return nil, "", err
+	}
+	return cached.Result, cached.Params, nil
+}
+
+// profile returns the encoded loudness profile stored for the audio content
+// of pathToFile, if any.
+func (c *Cache) profile(pathToFile string) ([]byte, error) {
+	_, hash, _, err := c.hash(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	var profile []byte
+	err = c.view(func(tx *bolt.Tx) error {
+		// only valid within the transaction
+		profile = bytes.Clone(tx.Bucket(cacheProfilesBucket).Get([]byte(hash)))
+		return nil
+	})
+	return profile, err
+}
+
+// store records res, computed by version with the settings fingerprinted as
+// params, and the encoded loudness profile, if any, for the audio content of
+// pathToFile.
+func (c *Cache) store(pathToFile, params, version string, res *Result, profile []byte) error {
+	abs, hash, rec, err := c.hash(pathToFile)
+	if err != nil {
+		return err
+	}
+	if rec != nil {
+		if err := c.put(cacheFilesBucket, abs, rec); err != nil {
+			return err
+		}
+	}
+	if profile != nil {
+		if err := c.putRaw(cacheProfilesBucket, hash, profile); err != nil {
+			return err
+		}
+	}
+	return c.put(cacheResultsBucket, hash, cachedResult{Params: params, Version: version, Result: res})
+}
+
+// put stores val as JSON under key in bucket.
+func (c *Cache) put(bucket []byte, key string, val any) error {
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return c.putRaw(bucket, key, raw)
+}
+
+// putRaw stores raw under key in bucket.
+func (c *Cache) putRaw(bucket []byte, key string, raw []byte) error {
+	return c.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), raw)
+	})
+}
+
+// cachedTags converts a stored result into the tags WriteTags would have
+// written for it, so it takes the same fast path as tagged files.
+func cachedTags(res *Result) (map[string]string, error) {
+	tags, err := resultTags(res)
+	if err != nil {
+		return nil, err
+	}
+	tags["replaygain_track_gain"] = res.Amplify
+	return tags, nil
+}
@@ -0,0 +1,119 @@
+package cue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CacheSuite struct {
+	suite.Suite
+}
+
+func TestCacheSuite(t *testing.T) {
+	suite.Run(t, &CacheSuite{})
+}
+
+func (s *CacheSuite) openCache() *Cache {
+	cache, err := OpenCache(filepath.Join(s.T().TempDir(), "cache.db"))
+	s.Require().NoError(err)
+	return cache
+}
+
+func (s *CacheSuite) TestContentHash() {
+	dir := s.T().TempDir()
+	path := filepath.Join(dir, "a.mp3")
+	s.Require().NoError(os.WriteFile(path, mp3Frames, 0o644))
+	before, err := contentHash(path)
+	s.Require().NoError(err)
+
+	// tags don't change the hash
+	s.Require().NoError(writeMP3Tags(path, map[string]string{"liq_cue_in": "1.200"}))
+	after, err := contentHash(path)
+	s.Require().NoError(err)
+	s.Equal(before, after)
+
+	// the audio does
+	s.Require().NoError(os.WriteFile(path, append(mp3Frames, 0xff), 0o644))
+	changed, err := contentHash(path)
+	s.Require().NoError(err)
+	s.NotEqual(before, changed)
+
+	// the same for a moov atom growing in front of the media data
+	m4a := filepath.Join(dir, "a.m4a")
+	s.Require().NoError(os.WriteFile(m4a, testMP4File(true), 0o644))
+	before, err = contentHash(m4a)
+	s.Require().NoError(err)
+	s.Require().NoError(writeMP4Tags(m4a, map[string]string{"liq_cue_in": "1.200"}))
+	after, err = contentHash(m4a)
+	s.Require().NoError(err)
+	s.Equal(before, after)
+}
+
+func (s *CacheSuite) TestLookup() {
+	cache := s.openCache()
+	dir := s.T().TempDir()
+	path := filepath.Join(dir, "a.mp3")
+	s.Require().NoError(os.WriteFile(path, mp3Frames, 0o644))
+
+	res, _, err := cache.lookup(path)
+	s.Require().NoError(err)
+	s.Nil(res)
+	// a miss remembers the hash already, for store
+	_, _, rec, err := cache.hash(path)
+	s.Require().NoError(err)
+	s.Nil(rec)
+
+	stored := sidecarResult
+	s.Require().NoError(cache.store(path, "p1", "1.0.0", &stored, nil))
+	res, params, err := cache.lookup(path)
+	s.Require().NoError(err)
+	s.Equal(&stored, res)
+	s.Equal("p1", params)
+
+	// a moved and retagged file is still found
+	moved := filepath.Join(dir, "b.mp3")
+	s.Require().NoError(os.Rename(path, moved))
+	s.Require().NoError(writeMP3Tags(moved, map[string]string{"title": "B"}))
+	res, _, err = cache.lookup(moved)
+	s.Require().NoError(err)
+	s.Equal(&stored, res)
+
+	// and its hash is remembered
+	abs, _, rec, err := cache.hash(moved)
+	s.Require().NoError(err)
+	s.Equal(moved, abs)
+	s.Nil(rec)
+}
+
+// TestCalcCache checks that a cached result is used without ffprobe, and
+// only for the settings it was computed with.
+func (s *CacheSuite) TestCalcCache() {
+	path := filepath.Join(s.T().TempDir(), "a.aac")
+	s.Require().NoError(os.WriteFile(path, mp3Frames, 0o644))
+
+	c := NewCalculator(nil)
+	c.cache = s.openCache()
+	stored := sidecarResult
+	s.Require().NoError(c.cache.store(path, c.paramsFingerprint(), "", &stored, nil))
+
+	res, err := c.Calc(path)
+	s.Require().NoError(err)
+	s.True(res.Cached())
+	s.InDelta(178.0, res.CueOut, 1e-9)
+	s.Equal("-4.000 dB", res.Amplify)
+
+	// a different target is applied on the fast path
+	c.targetLoudness = -16
+	res, err = c.Calc(path)
+	s.Require().NoError(err)
+	s.Equal("-2.000 dB", res.Amplify)
+
+	// different thresholds need an analysis
+	c.silence = -50
+	tags, err := c.readTags(s.T().Context(), path)
+	s.Require().NoError(err)
+	s.IsType(ErrRequireAnalysis{}, c.doPreAnalysis(tags))
+}
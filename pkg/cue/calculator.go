@@ -2,21 +2,19 @@ package cue
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
 	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
 const (
-	// location of the ffmpeg binary
-	ffmpeg = "ffmpeg"
-	// location of the ffprobe binary
-	ffprobe = "ffprobe"
 	// Reference Loudness Target
 	defaultTargetLUFS = -18.0
 	// LU below average track loudness for cue-in/cue-out trigger ("silence");
@@ -24,6 +22,10 @@ const (
 	defaultSilence = -42.0
 	// LU below average for overlay trigger (start next song)
 	defaultOverlayLU = -8.0
+	// LU below average the main body of a track stays above after its intro
+	defaultIntroLU = -6.0
+	// seconds the loudness must stay above the intro level to end the intro
+	introSustainSeconds = 2.0
 	// more than this many seconds below the overlay level is a "long tail"
 	defaultLongTailSeconds = 15.0
 	// extra LU below overlay to find the overlap point on long-tail songs
@@ -33,6 +35,9 @@ const (
 	// min. seconds of silence to detect a blank
 	defaultBlankSkip        = 0.0
 	defaultExecutionTimeout = 10 * time.Second
+	// revision of the analysis, part of the parameter fingerprint; bump it when
+	// a change to measure or analyze alters the results for the same settings
+	analysisRevision = 1
 )
 
 var (
@@ -53,14 +58,23 @@ var (
 		"liq_amplify",
 		"liq_blankskip",
 		"liq_blank_skipped",
+		"liq_bpm",
 		"liq_cross_duration",
 		"liq_cross_start_next",
+		"liq_cross_start_next_raw",
 		"liq_cue_duration",
 		"liq_cue_file",
 		"liq_cue_in",
+		"liq_cue_in_raw",
 		"liq_cue_out",
+		"liq_ending",
 		"liq_fade_in",
 		"liq_fade_out",
+		"liq_first_beat",
+		"liq_first_downbeat",
+		"liq_gocue_params",
+		"liq_gocue_version",
+		"liq_intro_end",
 		"liq_longtail",
 		"liq_loudness",
 		"liq_loudness_range",
@@ -76,6 +90,18 @@ var (
 	}
 )
 
+// ReanalyzeReason - a set of conditions under which cached tags are considered
+// stale and a full analysis is run instead of the fast path
+type ReanalyzeReason uint
+
+const (
+	// ReanalyzeReferenceLoudness - the cached liq_reference_loudness differs from the target
+	ReanalyzeReferenceLoudness ReanalyzeReason = 1 << iota
+	// ReanalyzeThresholds - the cached results carry no liq_gocue_params fingerprint
+	// to check (e.g. written by other tools)
+	ReanalyzeThresholds
+)
+
 // CalculatorOptions - audio file processing options
 type CalculatorOptions struct {
 	ExecutionTimeout time.Duration
@@ -83,25 +109,83 @@ type CalculatorOptions struct {
 	BlankSkip        float64
 	Silence          float64
 	Overlay          float64
-	LongtailSeconds  float64
-	Extra            float64
-	Drop             float64
-	NoClip           bool
+	// Intro is the level, in LU relative to the integrated loudness, the
+	// track has to stay above after its intro (liq_intro_end)
+	Intro           float64
+	LongtailSeconds float64
+	Extra           float64
+	Drop            float64
+	NoClip          bool
+	// WriteTags persists freshly analysed results as tags into the audio file
+	WriteTags bool
+	// WriteReplayGain also writes ReplayGain 2.0 tags (plus R128_TRACK_GAIN for Opus)
+	WriteReplayGain bool
+	// ForceAnalysis always runs a full analysis, ignoring any cached tags
+	ForceAnalysis bool
+	// Reanalyze lists the extra conditions that invalidate cached tags
+	Reanalyze ReanalyzeReason
+	// MaxDuration makes Calc reject longer files with ErrTooLong; zero means no limit
+	MaxDuration time.Duration
+	// Sidecar reads cached results from, and writes fresh ones to, a
+	// "<file>.gocue.json" sidecar file instead of relying on file tags only
+	Sidecar bool
+	// SidecarDir keeps the sidecar files in a directory tree mirroring the
+	// absolute audio file paths, instead of next to the files; implies Sidecar
+	SidecarDir string
+	// Cache looks up and stores results in an analysis cache database
+	Cache *Cache
+	// AppVersion is stored as liq_gocue_version along with the results
+	AppVersion string
+	// FFmpeg configures the ffmpeg and ffprobe binaries used for probing,
+	// analysis and tag writing
+	FFmpeg FFmpeg
+	// Prober replaces ffprobe for reading tags and durations
+	Prober Prober
+	// LoudnessSource replaces ffmpeg's ebur128 filter for the analysis
+	LoudnessSource LoudnessSource
+	// FFmpegOnly probes and analyses WAV, AIFF and FLAC files with ffmpeg as
+	// well, instead of decoding them natively
+	FFmpegOnly bool
+	// Beats detects the tempo and beat grid (liq_bpm, liq_first_beat and
+	// liq_first_downbeat)
+	Beats bool
+	// Snap moves liq_cross_start_next to the nearest beat or downbeat of the
+	// grid, keeping the analysed value as liq_cross_start_next_raw; implies
+	// Beats
+	Snap SnapTo
+	// Log receives the diagnostics of the analysis, such as the levels and
+	// overlay candidates it considered, e.g. os.Stderr; nil discards them
+	Log io.Writer
+	// SnapCueIn also moves liq_cue_in to the beat or downbeat at or before it,
+	// keeping the analysed value as liq_cue_in_raw; with Snap left at SnapOff,
+	// both points are snapped to beats
+	SnapCueIn bool
+}
+
+// DefaultCalculatorOptions - the options NewCalculator uses when given nil
+func DefaultCalculatorOptions() CalculatorOptions {
+	return CalculatorOptions{
+		ExecutionTimeout: defaultExecutionTimeout,
+		TargetLoudness:   defaultTargetLUFS,
+		BlankSkip:        defaultBlankSkip,
+		Silence:          defaultSilence,
+		Overlay:          defaultOverlayLU,
+		Intro:            defaultIntroLU,
+		LongtailSeconds:  defaultLongTailSeconds,
+		Extra:            longTailExtraLU,
+		Drop:             defaultSustainedLoudnessDrop,
+	}
 }
 
 // NewCalculator - create a new calculator
 func NewCalculator(opts *CalculatorOptions) *Calculator {
 	if opts == nil {
-		opts = &CalculatorOptions{
-			ExecutionTimeout: defaultExecutionTimeout,
-			TargetLoudness:   defaultTargetLUFS,
-			BlankSkip:        defaultBlankSkip,
-			Silence:          defaultSilence,
-			Overlay:          defaultOverlayLU,
-			LongtailSeconds:  defaultLongTailSeconds,
-			Extra:            longTailExtraLU,
-			Drop:             defaultSustainedLoudnessDrop,
-		}
+		defaults := DefaultCalculatorOptions()
+		opts = &defaults
+	}
+	snap := opts.Snap
+	if opts.SnapCueIn && snap == SnapOff {
+		snap = SnapBeat
 	}
 	return &Calculator{
 		executionTimeout: opts.ExecutionTimeout,
@@ -109,10 +193,28 @@ func NewCalculator(opts *CalculatorOptions) *Calculator {
 		blankSkip:        opts.BlankSkip,
 		silence:          opts.Silence,
 		overlay:          opts.Overlay,
+		intro:            opts.Intro,
 		longtailSeconds:  opts.LongtailSeconds,
 		extra:            opts.Extra,
 		drop:             opts.Drop,
 		noClip:           opts.NoClip,
+		writeTags:        opts.WriteTags,
+		writeReplayGain:  opts.WriteReplayGain,
+		forceAnalysis:    opts.ForceAnalysis,
+		reanalyze:        opts.Reanalyze,
+		maxDuration:      opts.MaxDuration,
+		sidecar:          opts.Sidecar || opts.SidecarDir != "",
+		sidecarDir:       opts.SidecarDir,
+		cache:            opts.Cache,
+		appVersion:       opts.AppVersion,
+		ffmpeg:           opts.FFmpeg,
+		prober:           opts.Prober,
+		loudnessSource:   opts.LoudnessSource,
+		ffmpegOnly:       opts.FFmpegOnly,
+		beats:            opts.Beats || snap != SnapOff,
+		snap:             snap,
+		snapCueIn:        opts.SnapCueIn,
+		log:              opts.Log,
 	}
 }
 
@@ -123,86 +225,268 @@ type Calculator struct {
 	blankSkip        float64
 	silence          float64
 	overlay          float64
+	intro            float64
 	longtailSeconds  float64
 	extra            float64
 	drop             float64
 	noClip           bool
+	writeTags        bool
+	writeReplayGain  bool
+	forceAnalysis    bool
+	reanalyze        ReanalyzeReason
+	maxDuration      time.Duration
+	sidecar          bool
+	sidecarDir       string
+	cache            *Cache
+	appVersion       string
+	ffmpeg           FFmpeg
+	// prober and loudnessSource default to backend() if nil
+	prober         Prober
+	loudnessSource LoudnessSource
+	ffmpegOnly     bool
+	beats          bool
+	snap           SnapTo
+	snapCueIn      bool
+	log            io.Writer
 }
 
 // Calc returns actual results
 func (c *Calculator) Calc(pathToFile string) (*Result, error) {
-	tags, err := c.probe(pathToFile)
+	return c.CalcContext(context.Background(), pathToFile)
+}
+
+// CalcContext - like Calc, but the analysis is aborted once ctx is done;
+// running ffmpeg/ffprobe processes are killed. ExecutionTimeout still limits
+// every single process.
+func (c *Calculator) CalcContext(ctx context.Context, pathToFile string) (*Result, error) {
+	return c.CalcWithMetadataContext(ctx, pathToFile, nil)
+}
+
+// CalcWithMetadata - like Calc, but merges metadata (e.g. Liquidsoap's JSON
+// request metadata, see ParseMetadata) over the tags read from the file, and
+// keeps user-set liq_cue_in, liq_cue_out and liq_cross_start_next values
+// instead of the analysed ones
+func (c *Calculator) CalcWithMetadata(pathToFile string, metadata map[string]string) (*Result, error) {
+	return c.CalcWithMetadataContext(context.Background(), pathToFile, metadata)
+}
+
+// CalcWithMetadataContext - CalcWithMetadata with the cancellation of CalcContext
+func (c *Calculator) CalcWithMetadataContext(ctx context.Context, pathToFile string, metadata map[string]string) (*Result, error) {
+	res, err := c.calc(ctx, pathToFile, metadata)
 	if err != nil {
 		return nil, err
 	}
-	err = c.doPreAnalysis(tags)
-	if err == nil {
-		c.populate(tags)
-		c.adjustLoudness(tags)
-		return parseTags(tags), nil
+	applyOverrides(res, metadata)
+	return res, nil
+}
+
+func (c *Calculator) calc(ctx context.Context, pathToFile string, metadata map[string]string) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !c.forceAnalysis || c.maxDuration > 0 {
+		tags, err := c.readTags(ctx, pathToFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.checkDuration(tags); err != nil {
+			return nil, err
+		}
+		c.collectTags(tags, metadata)
+		if err := c.doPreAnalysis(tags); err == nil && !c.forceAnalysis {
+			c.populate(tags)
+			c.adjustLoudness(tags)
+			res := parseTags(tags)
+			res.cached = true
+			return res, nil
+		}
+	}
+	// with other settings, a stored loudness profile saves decoding the file
+	var prof *profile
+	if !c.forceAnalysis {
+		prof = c.storedProfile(pathToFile)
 	}
-	return c.scan(pathToFile)
+	if prof == nil {
+		var err error
+		if prof, err = c.measure(ctx, pathToFile); err != nil {
+			return nil, err
+		}
+	} else if c.beats && prof.beats == nil {
+		var err error
+		if prof.beats, err = c.detectBeats(ctx, pathToFile, nil); err != nil {
+			return nil, err
+		}
+	}
+	res := c.analyze(prof)
+
+	var encoded []byte
+	if c.sidecar || c.cache != nil {
+		var err error
+		if encoded, err = encodeProfile(prof); err != nil {
+			fmt.Fprintf(os.Stderr, "profile encoding error: %s\n", err.Error())
+		}
+	}
+	if c.writeTags || c.writeReplayGain {
+		// the analysis result is still valid if the file can't be tagged
+		if err := c.WriteTagsContext(ctx, pathToFile, res); err != nil {
+			fmt.Fprintf(os.Stderr, "tag write error: %s\n", err.Error())
+		}
+	}
+	if c.sidecar {
+		if err := c.writeSidecar(pathToFile, res, encoded); err != nil {
+			fmt.Fprintf(os.Stderr, "sidecar write error: %s\n", err.Error())
+		}
+	}
+	if c.cache != nil {
+		// after writing tags, so the new size and modification time are recorded
+		if err := c.cache.store(pathToFile, c.paramsFingerprint(), c.appVersion, res, encoded); err != nil {
+			fmt.Fprintf(os.Stderr, "cache write error: %s\n", err.Error())
+		}
+	}
+	return res, nil
 }
 
-func (c *Calculator) probe(pathToFile string) (map[string]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), c.executionTimeout)
-	defer cancel()
-	cmd := exec.CommandContext(ctx, ffprobe,
-		"-v", "quiet",
-		"-show_entries",
-		"stream=codec_name,duration,bit_rate,sample_fmt,sample_rate,time_base,codec_type:stream_tags:format=duration:format_tags",
-		"-of", "json=compact=1",
-		pathToFile,
-	)
-	res, err := cmd.Output()
+// storedProfile returns the loudness profile of pathToFile kept in its
+// sidecar or in the cache, if any.
+func (c *Calculator) storedProfile(pathToFile string) *profile {
+	var encoded []byte
+	if c.sidecar {
+		sc, err := c.loadSidecar(pathToFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sidecar read error: %s\n", err.Error())
+		}
+		if sc != nil {
+			encoded = sc.Profile
+		}
+	}
+	if encoded == nil && c.cache != nil {
+		var err error
+		if encoded, err = c.cache.profile(pathToFile); err != nil {
+			fmt.Fprintf(os.Stderr, "cache read error: %s\n", err.Error())
+		}
+	}
+	if encoded == nil {
+		return nil
+	}
+	prof, err := decodeProfile(encoded)
 	if err != nil {
-		return nil, fmt.Errorf("ffprobe failed for %q: %w", pathToFile, err)
+		fmt.Fprintf(os.Stderr, "profile read error: %s\n", err.Error())
+		return nil
 	}
+	return prof
+}
 
-	// ffprobe always emits tag values as JSON strings, so decode straight into
-	// a typed struct instead of map[string]any + unchecked type assertions
-	// (the latter panic on e.g. OGG/Opus, where stream duration is often absent).
-	var probed struct {
-		Streams []struct {
-			CodecType string            `json:"codec_type"`
-			Duration  string            `json:"duration"`
-			Tags      map[string]string `json:"tags"`
-		} `json:"streams"`
-		Format struct {
-			Duration string `json:"duration"`
-		} `json:"format"`
+// checkDuration returns ErrTooLong if the probed duration exceeds maxDuration.
+func (c *Calculator) checkDuration(tags map[string]string) error {
+	if c.maxDuration <= 0 {
+		return nil
 	}
-	if err := json.Unmarshal(res, &probed); err != nil {
-		return nil, fmt.Errorf("cannot parse ffprobe output for %q: %w", pathToFile, err)
+	dur, err := strconv.ParseFloat(tags["duration"], 64)
+	if err != nil {
+		return nil // unknown durations are analysed, the timeout still applies
 	}
+	if d := time.Duration(dur * float64(time.Second)); d > c.maxDuration {
+		return ErrTooLong{duration: d, max: c.maxDuration}
+	}
+	return nil
+}
 
-	tags := make(map[string]string)
-	for _, s := range probed.Streams {
-		if s.CodecType != "audio" {
-			continue
+// readTags returns the verifyTags subset of the file's tags. A current
+// sidecar file or a cached result takes the place of the file tags; formats
+// with a native tag reader skip ffprobe if their tags hold the duration
+// written by WriteTags; all other files are probed.
+func (c *Calculator) readTags(ctx context.Context, pathToFile string) (map[string]string, error) {
+	if c.sidecar {
+		raw, err := c.readSidecar(pathToFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sidecar read error: %s\n", err.Error())
 		}
-		// stream-level duration is often missing for containers like OGG/Opus;
-		// fall back to the container (format) duration in that case
-		if s.Duration != "" {
-			tags["duration"] = s.Duration
-		} else if probed.Format.Duration != "" {
-			tags["duration"] = probed.Format.Duration
+		if raw != nil {
+			tags := make(map[string]string)
+			c.collectTags(tags, raw)
+			return tags, nil
 		}
-		for key, val := range s.Tags {
-			if !slices.Contains(verifyTags, key) {
-				continue
-			}
-			clean, err := takePureValue(key, val)
+	}
+	if c.cache != nil {
+		res, params, err := c.cache.lookup(pathToFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cache read error: %s\n", err.Error())
+		}
+		if res != nil {
+			raw, err := cachedTags(res)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "tag read error: %s\n", err.Error())
-				continue
+				return nil, err
 			}
-			tags[key] = clean
+			raw["liq_gocue_params"] = params
+			tags := make(map[string]string)
+			c.collectTags(tags, raw)
+			return tags, nil
 		}
 	}
+	if read, ok := nativeTagReaders[strings.ToLower(filepath.Ext(pathToFile))]; ok {
+		if raw, err := read(pathToFile); err == nil {
+			tags := make(map[string]string)
+			c.collectTags(tags, raw)
+			if _, ok := tags["duration"]; ok {
+				return tags, nil
+			}
+		}
+	}
+	return c.probe(ctx, pathToFile)
+}
+
+// probe reads the tags of the file with the Prober within the execution
+// timeout.
+func (c *Calculator) probe(ctx context.Context, pathToFile string) (map[string]string, error) {
+	var prober Prober = c.backend(pathToFile)
+	if c.prober != nil {
+		prober = c.prober
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.executionTimeout)
+	defer cancel()
+	probed, err := prober.Probe(ctx, pathToFile)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("cannot probe %q: %w", pathToFile, context.Cause(ctx))
+		}
+		return nil, err
+	}
+	tags := make(map[string]string)
+	c.collectTags(tags, probed)
 	return tags, nil
 }
 
+// backend returns the default Prober and LoudnessSource for the file: Native
+// for the formats it decodes, falling back to ffmpeg where it fails, unless
+// FFmpegOnly is set, and ffmpeg for all other formats. Native only detects
+// onsets if beats are wanted.
+func (c *Calculator) backend(pathToFile string) interface {
+	Prober
+	LoudnessSource
+} {
+	if !c.ffmpegOnly && hasNativeDecoder(pathToFile) {
+		return fallback{native: Native{DetectOnsets: c.beats}, ffmpeg: c.ffmpeg}
+	}
+	return c.ffmpeg
+}
+
+// collectTags copies the verifyTags subset of probed into tags. Keys are
+// matched case-insensitively, since Vorbis comments are often upper-case.
+func (c *Calculator) collectTags(tags, probed map[string]string) {
+	for key, val := range probed {
+		key = strings.ToLower(key)
+		if !slices.Contains(verifyTags, key) {
+			continue
+		}
+		clean, err := takePureValue(key, val)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tag read error: %s\n", err.Error())
+			continue
+		}
+		tags[key] = clean
+	}
+}
+
 func (c *Calculator) adjustLoudness(tags map[string]string) {
 	// create replaygain_track_gain from Opus R128_TRACK_GAIN (ref: -23 LUFS)
 	if r128TrackGain, ok := tags["r128_track_gain"]; ok {
@@ -279,6 +563,13 @@ func takePureValue(key, val string) (string, error) {
 // doPreAnalysis tries to avoid re-analysis when we have enough tag data but a
 // different loudness target; it returns ErrRequireAnalysis if a full scan is needed.
 func (c *Calculator) doPreAnalysis(tags map[string]string) error {
+	// liq_* tags written without ReplayGain ones carry the track gain as
+	// liq_amplify only
+	if _, ok := tags["replaygain_track_gain"]; !ok {
+		if liqAmplify, ok := tags["liq_amplify"]; ok {
+			tags["replaygain_track_gain"] = liqAmplify
+		}
+	}
 	for _, bt := range baseTags {
 		if _, ok := tags[bt]; !ok {
 			return ErrRequireAnalysis{inner: fmt.Errorf("tag '%s' is missing", bt)}
@@ -293,6 +584,11 @@ func (c *Calculator) doPreAnalysis(tags map[string]string) error {
 	if !refLoudnessOK {
 		return ErrRequireAnalysis{inner: fmt.Errorf("tag liq_reference_loudness is missing")}
 	}
+	if c.reanalyze&ReanalyzeReferenceLoudness != 0 {
+		if err := c.requireTagValue(tags, "liq_reference_loudness", c.targetLoudness); err != nil {
+			return err
+		}
+	}
 	// liq_amplify is recomputed from liq_loudness by calcAmplify below, so we
 	// only record the requested reference loudness here, under the same guard
 	// (both inputs must be valid numbers).
@@ -333,6 +629,15 @@ func (c *Calculator) doPreAnalysis(tags map[string]string) error {
 		}
 	}
 
+	// cue points computed with other cue-affecting settings are stale
+	if params, ok := tags["liq_gocue_params"]; ok {
+		if params != c.paramsFingerprint() {
+			return ErrRequireAnalysis{inner: fmt.Errorf("liq_gocue_params [%s] is different from the requested [%s]", params, c.paramsFingerprint())}
+		}
+	} else if c.reanalyze&ReanalyzeThresholds != 0 {
+		return ErrRequireAnalysis{inner: fmt.Errorf("tag liq_gocue_params is missing")}
+	}
+
 	// liq_loudness_range is only informational but we want to show correct values;
 	// we can't blindly take replaygain_track_range—it might be in a different unit
 	if _, ok := tags["liq_loudness_range"]; !ok {
@@ -341,6 +646,23 @@ func (c *Calculator) doPreAnalysis(tags map[string]string) error {
 	return nil
 }
 
+// requireTagValue returns ErrRequireAnalysis unless the tag holds want (to the
+// 3 decimals tags are written with).
+func (c *Calculator) requireTagValue(tags map[string]string, key string, want float64) error {
+	val, ok := tags[key]
+	if !ok {
+		return ErrRequireAnalysis{inner: fmt.Errorf("tag %s is missing", key)}
+	}
+	got, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return ErrRequireAnalysis{inner: fmt.Errorf("cannot parse %s: %w", key, err)}
+	}
+	if math.Abs(got-want) >= 0.0005 {
+		return ErrRequireAnalysis{inner: fmt.Errorf("%s is different from the requested one", key)}
+	}
+	return nil
+}
+
 func (c *Calculator) populate(tags map[string]string) {
 	// fill in tags not already present or computed by doPreAnalysis
 	if _, ok := tags["liq_longtail"]; !ok {
@@ -368,6 +690,29 @@ func (c *Calculator) populate(tags map[string]string) {
 	if _, ok := tags["liq_reference_loudness"]; !ok {
 		tags["liq_reference_loudness"] = fmt.Sprintf("%.3f", c.targetLoudness)
 	}
+	// tags written before fades were computed: fall back to Liquidsoap's
+	// defaults, keeping the fade-out within the overlap
+	if _, ok := tags["liq_fade_in"]; !ok {
+		tags["liq_fade_in"] = fmt.Sprintf("%.3f", minFadeDuration)
+	}
+	if _, ok := tags["liq_fade_out"]; !ok {
+		cueOut, _ := strconv.ParseFloat(tags["liq_cue_out"], 64)
+		crossStartNext, _ := strconv.ParseFloat(tags["liq_cross_start_next"], 64)
+		tags["liq_fade_out"] = fmt.Sprintf("%.3f", max(min(defaultFadeOut, cueOut-crossStartNext), 0))
+	}
+	// tags written before endings were classified: as far as the flags tell;
+	// liq_sustained_ending holds for many steady fades too, so it is no sign
+	// of a sustained ending
+	if _, ok := tags["liq_ending"]; !ok {
+		tags["liq_ending"] = EndingFade
+		if tags["liq_longtail"] == "true" {
+			tags["liq_ending"] = EndingLongTail
+		}
+	}
+	// tags written before intros were detected: no talk-over time
+	if _, ok := tags["liq_intro_end"]; !ok {
+		tags["liq_intro_end"] = tags["liq_cue_in"]
+	}
 
 	// for ReplayGain tag writing
 	if _, ok := tags["replaygain_track_gain"]; !ok {
@@ -384,6 +729,19 @@ func (c *Calculator) populate(tags map[string]string) {
 	}
 }
 
+// paramsFingerprint identifies the analysis revision and the settings the cue
+// points depend on; the loudness target and clipping prevention are applied
+// on the fast path, so they are left out. The beat settings are only added
+// with Beats, keeping the fingerprints of earlier results valid.
+func (c *Calculator) paramsFingerprint() string {
+	params := fmt.Sprintf("rev=%d silence=%.3f overlay=%.3f intro=%.3f longtail=%.3f extra=%.3f drop=%.3f blankskip=%.3f",
+		analysisRevision, c.silence, c.overlay, c.intro, c.longtailSeconds, c.extra, c.drop, c.blankSkip)
+	if c.beats {
+		params += fmt.Sprintf(" beats=true snap=%s snapcuein=%t", c.snap, c.snapCueIn)
+	}
+	return params
+}
+
 func (c *Calculator) calcAmplify(loudness, liqTruePeakDb float64) (amplify, amplifyCorrection float64) {
 	// check if we need to reduce the gain for true peaks
 	amplify = c.targetLoudness - loudness
@@ -1,8 +1,10 @@
 package cue
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
@@ -21,11 +23,11 @@ func TestCalculatorSuite(t *testing.T) {
 
 func (s *CalculatorSuite) TestProbe() {
 	calc := &Calculator{executionTimeout: 5 * time.Second}
-	res, err := calc.probe("test_data/classic.wav")
+	res, err := calc.probe(s.T().Context(), "test_data/classic.wav")
 	s.NoError(err)
 	fmt.Printf("gocue probing returned %+v\n", res)
 
-	res, err = calc.probe("test_data/sample.ogg")
+	res, err = calc.probe(s.T().Context(), "test_data/sample.ogg")
 	s.NoError(err)
 	fmt.Printf("gocue probing returned %+v\n", res)
 }
@@ -94,13 +96,13 @@ func (s *CalculatorSuite) TestScan() {
 	for _, tc := range tests {
 		s.Run(tc.title, func() {
 			calculator := Calculator{targetLoudness: -16.4, executionTimeout: 5 * time.Second}
-			_, err := calculator.scan(tc.file)
+			_, err := calculator.measure(s.T().Context(), tc.file)
 			s.Equal(tc.err, err)
 		})
 	}
 }
 
-// TestScanRegression pins scan() output against values verified to be identical
+// TestScanRegression pins the analysis output against values verified to be identical
 // to the upstream Python autocue (cue_file) for the bundled fixtures. It guards
 // the cue/overlay math — in particular the max(t, total-t) "mirror" formulas —
 // against accidental regressions. Time values use a small delta; ffmpeg's
@@ -127,8 +129,9 @@ func (s *CalculatorSuite) TestScanRegression() {
 			}
 			calc := NewCalculator(nil)
 			calc.executionTimeout = 30 * time.Second
-			res, err := calc.scan(tc.file)
+			p, err := calc.measure(s.T().Context(), tc.file)
 			s.Require().NoError(err)
+			res := calc.analyze(p)
 			s.InDelta(tc.cueIn, res.CueIn, 0.05, "liq_cue_in")
 			s.InDelta(tc.cueOut, res.CueOut, 0.05, "liq_cue_out")
 			s.InDelta(tc.crossStartNext, res.CrossStartNext, 0.05, "liq_cross_start_next")
@@ -170,6 +173,158 @@ func (s *CalculatorSuite) TestParseTagsReferenceLoudness() {
 	s.Equal("-1.200 dBFS", res.TruePeakDb)
 }
 
+// TestDoPreAnalysisReanalyze checks that the opt-in reanalyze reasons reject
+// cached tags written for different settings, and accept matching ones.
+func (s *CalculatorSuite) TestDoPreAnalysisReanalyze() {
+	cached := func() map[string]string {
+		return map[string]string{
+			"duration":               "120.0",
+			"liq_cue_in":             "0.0",
+			"liq_cue_out":            "118.2",
+			"liq_cross_start_next":   "115.1",
+			"replaygain_track_gain":  "-3.5",
+			"liq_amplify":            "-3.5",
+			"liq_reference_loudness": "-18.000",
+			"liq_true_peak":          "0.9",
+			"liq_true_peak_db":       "-0.9",
+			"liq_loudness":           "-14.5",
+			"liq_loudness_range":     "6.1",
+		}
+	}
+	tests := []struct {
+		title     string
+		opts      CalculatorOptions
+		requireRe bool
+	}{
+		{"no reasons", CalculatorOptions{TargetLoudness: -16}, false},
+		{"same reference", CalculatorOptions{TargetLoudness: -18, Reanalyze: ReanalyzeReferenceLoudness}, false},
+		{"other reference", CalculatorOptions{TargetLoudness: -16, Reanalyze: ReanalyzeReferenceLoudness}, true},
+		{"no fingerprint", CalculatorOptions{TargetLoudness: -18, Silence: -42, Overlay: -8, Reanalyze: ReanalyzeThresholds}, true},
+	}
+	for _, tc := range tests {
+		s.Run(tc.title, func() {
+			err := NewCalculator(&tc.opts).doPreAnalysis(cached())
+			if tc.requireRe {
+				s.IsType(ErrRequireAnalysis{}, err)
+			} else {
+				s.NoError(err)
+			}
+		})
+	}
+
+	// a fingerprint is always checked
+	s.Run("fingerprint", func() {
+		opts := CalculatorOptions{TargetLoudness: -18, Silence: -45, Overlay: -8, Drop: 40, Reanalyze: ReanalyzeThresholds}
+		tags := cached()
+		tags["liq_gocue_params"] = NewCalculator(&opts).paramsFingerprint()
+		s.NoError(NewCalculator(&opts).doPreAnalysis(tags))
+
+		opts.Drop, opts.Reanalyze = 30, 0
+		tags["liq_gocue_params"] = NewCalculator(&CalculatorOptions{Silence: -45, Overlay: -8, Drop: 40}).paramsFingerprint()
+		s.IsType(ErrRequireAnalysis{}, NewCalculator(&opts).doPreAnalysis(tags))
+	})
+}
+
+func (s *CalculatorSuite) TestCheckDuration() {
+	c := NewCalculator(&CalculatorOptions{MaxDuration: 20 * time.Minute})
+	s.NoError(c.checkDuration(map[string]string{"duration": "1199.9"}))
+	s.NoError(c.checkDuration(map[string]string{}))
+	s.Equal(ErrTooLong{duration: 7200 * time.Second, max: 20 * time.Minute},
+		c.checkDuration(map[string]string{"duration": "7200"}))
+
+	s.NoError(NewCalculator(nil).checkDuration(map[string]string{"duration": "7200"}))
+}
+
+// TestCalcContext checks that a cancelled analysis returns promptly with the
+// cancellation, killing the running ffmpeg.
+func (s *CalculatorSuite) TestCalcContext() {
+	ctx, cancel := context.WithCancel(s.T().Context())
+	cancel()
+	_, err := NewCalculator(nil).CalcContext(ctx, "test_data/sample.ogg")
+	s.ErrorIs(err, context.Canceled)
+
+	if runtime.GOOS == "windows" {
+		s.T().Skip("needs a shell script in place of ffmpeg")
+	}
+	opts := DefaultCalculatorOptions()
+	opts.ForceAnalysis = true
+	// an ffmpeg which never finishes on its own
+	opts.FFmpeg.FFmpegPath = testScript(s.T(), "exec sleep 60")
+	opts.ExecutionTimeout = time.Minute
+	ctx, cancel = context.WithTimeout(s.T().Context(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = NewCalculator(&opts).CalcContext(ctx, "test_data/sample.ogg")
+	s.ErrorIs(err, context.DeadlineExceeded)
+	s.Contains(err.Error(), "aborted")
+	s.Less(time.Since(start), killWaitDelay)
+
+	// the execution timeout still applies per process
+	opts.ExecutionTimeout = 200 * time.Millisecond
+	_, err = NewCalculator(&opts).CalcContext(s.T().Context(), "test_data/sample.ogg")
+	s.ErrorContains(err, "timed out")
+}
+
+// TestCalcFades checks the fade recommendations on synthetic loudness curves:
+// a ramped intro, a hard start, a natural fade-out and a sustained ending.
+func (s *CalculatorSuite) TestCalcFades() {
+	// 100ms frames from 0 to 30s; loud (-14 LUFS) between 3s and 25s, with
+	// linear ramps of 2s in and 5s out down to -60 LUFS
+	curve := func(rampIn, rampOut float64) []Frame {
+		frames := make([]Frame, 0, 300)
+		for i := range 300 {
+			t := float64(i) / 10
+			l := -14.0
+			switch {
+			case t < 3-rampIn || t > 25+rampOut:
+				l = -60
+			case t < 3:
+				l = -14 - 46*(3-t)/rampIn
+			case t > 25:
+				l = -14 - 46*(t-25)/rampOut
+			}
+			frames = append(frames, Frame{PTSTime: t, Loudness: l})
+		}
+		return frames
+	}
+	c := NewCalculator(nil) // overlay -8, extra -12 around -14 LUFS
+
+	fadeIn, fadeOut := c.calcFades(curve(2, 5), -14, 1.0, 30.0, 25.5, false)
+	s.InDelta(1.6, fadeIn, 0.11, "ramped intro")
+	s.InDelta(30.0-(25+5*20.0/46), fadeOut, 0.11, "natural fade-out below overlay+extra")
+
+	fadeIn, fadeOut = c.calcFades(curve(0.01, 5), -14, 3.0, 30.0, 25.5, true)
+	s.InDelta(minFadeDuration, fadeIn, 1e-9, "hard start")
+	s.InDelta(4.5, fadeOut, 1e-9, "sustained ending fades the whole overlap")
+
+	fadeIn, fadeOut = c.calcFades(curve(2, 5), -14, 1.0, 30.0, 30.0, false)
+	s.InDelta(1.6, fadeIn, 0.11)
+	s.Zero(fadeOut, "no overlap, no fade-out")
+}
+
+func (s *CalculatorSuite) TestPopulateFades() {
+	tags := map[string]string{"liq_cue_out": "118.2", "liq_cross_start_next": "117.0"}
+	NewCalculator(nil).populate(tags)
+	s.Equal("0.100", tags["liq_fade_in"])
+	s.Equal("1.200", tags["liq_fade_out"])
+
+	res := parseTags(tags)
+	s.InDelta(1.2, res.CrossDuration, 1e-9)
+	s.InDelta(1.2, res.FadeOut, 1e-9)
+}
+
+func (s *CalculatorSuite) TestPopulateEnding() {
+	for flags, want := range map[[2]string]string{
+		{"false", "false"}: EndingFade,
+		{"false", "true"}:  EndingFade,
+		{"true", "true"}:   EndingLongTail,
+	} {
+		tags := map[string]string{"liq_longtail": flags[0], "liq_sustained_ending": flags[1]}
+		NewCalculator(nil).populate(tags)
+		s.Equal(want, tags["liq_ending"], flags)
+	}
+}
+
 // TestScanConcurrent runs the full pipeline on the fixtures from many goroutines
 // sharing one Calculator, so `go test -race` gets genuine concurrent access to
 // the package's shared state (regex, lookup slices, byte prefixes) and to the
@@ -214,10 +369,11 @@ func BenchmarkScan(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := calculator.scan("test_data/sample.ogg")
+		p, err := calculator.measure(b.Context(), "test_data/sample.ogg")
 		if err != nil {
 			b.Fatal(err)
 		}
+		calculator.analyze(p)
 	}
 }
 
@@ -236,11 +392,9 @@ frame: pts_time:0.9 lavfi.r128.M=-14.9 lavfi.r128.I=-18.5
 frame: pts_time:1.0 lavfi.r128.M=-13.2 lavfi.r128.I=-17.8
 lavfi.r128.true_peaks_ch0=0.123 lavfi.r128.LRA=5.2`
 
-	calculator := &Calculator{}
-
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		reader := strings.NewReader(sampleData)
-		_, _, _ = calculator.parseFFmpegOutput(reader)
+		_, _, _ = parseFFmpegOutput(reader)
 	}
 }
@@ -1,6 +1,9 @@
 package cue
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // ErrRequireAnalysis - not enough tags found, re-analysis is required
 type ErrRequireAnalysis struct {
@@ -10,3 +13,22 @@ type ErrRequireAnalysis struct {
 func (e ErrRequireAnalysis) Error() string {
 	return fmt.Sprintf("not enough data, re-analysis is required: %s", e.inner.Error())
 }
+
+// ErrUnsupportedFormat - tags cannot be written to this kind of file
+type ErrUnsupportedFormat struct {
+	ext string
+}
+
+func (e ErrUnsupportedFormat) Error() string {
+	return fmt.Sprintf("writing tags is not supported for %q files", e.ext)
+}
+
+// ErrTooLong - the file is longer than the configured maximum duration
+type ErrTooLong struct {
+	duration time.Duration
+	max      time.Duration
+}
+
+func (e ErrTooLong) Error() string {
+	return fmt.Sprintf("duration %s exceeds the maximum of %s", e.duration.Round(time.Second), e.max)
+}
@@ -0,0 +1,197 @@
+package cue
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// default ffmpeg and ffprobe binaries, looked up in PATH
+	defaultFFmpeg  = "ffmpeg"
+	defaultFFprobe = "ffprobe"
+	// how long Wait keeps reading the output of a killed ffmpeg/ffprobe
+	killWaitDelay = 2 * time.Second
+)
+
+// FFmpeg - the default Prober, LoudnessSource and OnsetSource, running
+// ffprobe and ffmpeg's ebur128 filter; ffmpeg also writes the tags of formats
+// without a native tag writer. The zero value looks both binaries up in PATH.
+type FFmpeg struct {
+	// FFmpegPath is the ffmpeg binary, "ffmpeg" if empty
+	FFmpegPath string
+	// FFprobePath is the ffprobe binary, "ffprobe" if empty
+	FFprobePath string
+	// FFmpegArgs are extra options for every ffmpeg run, placed before the
+	// input, e.g. []string{"-threads", "1"}
+	FFmpegArgs []string
+	// FFprobeArgs are extra options for every ffprobe run
+	FFprobeArgs []string
+}
+
+// Probe - reads the tags and duration of the file with ffprobe
+func (f FFmpeg) Probe(ctx context.Context, pathToFile string) (map[string]string, error) {
+	cmd := command(ctx, cmp.Or(f.FFprobePath, defaultFFprobe), append(slices.Clone(f.FFprobeArgs),
+		"-v", "quiet",
+		"-show_entries",
+		"stream=codec_name,duration,bit_rate,sample_fmt,sample_rate,time_base,codec_type:stream_tags:format=duration:format_tags",
+		"-of", "json=compact=1",
+		pathToFile,
+	)...)
+	res, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed for %q: %w", pathToFile, err)
+	}
+
+	// ffprobe always emits tag values as JSON strings, so decode straight into
+	// a typed struct instead of map[string]any + unchecked type assertions
+	// (the latter panic on e.g. OGG/Opus, where stream duration is often absent).
+	var probed struct {
+		Streams []struct {
+			CodecType string            `json:"codec_type"`
+			Duration  string            `json:"duration"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"streams"`
+		Format struct {
+			Duration string            `json:"duration"`
+			Tags     map[string]string `json:"tags"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(res, &probed); err != nil {
+		return nil, fmt.Errorf("cannot parse ffprobe output for %q: %w", pathToFile, err)
+	}
+
+	tags := make(map[string]string)
+	// MP3, FLAC, M4A and WAV carry their tags on the container; stream tags
+	// (Ogg) are applied afterwards and win on conflicts
+	copyLower(tags, probed.Format.Tags)
+	for _, s := range probed.Streams {
+		if s.CodecType != "audio" {
+			continue
+		}
+		// stream-level duration is often missing for containers like OGG/Opus;
+		// fall back to the container (format) duration in that case
+		if s.Duration != "" {
+			tags["duration"] = s.Duration
+		} else if probed.Format.Duration != "" {
+			tags["duration"] = probed.Format.Duration
+		}
+		copyLower(tags, s.Tags)
+	}
+	return tags, nil
+}
+
+// copyLower copies src into dst with lower-case keys, since Vorbis comments
+// are often upper-case.
+func copyLower(dst, src map[string]string) {
+	for _, key := range slices.Sorted(maps.Keys(src)) {
+		dst[strings.ToLower(key)] = src[key]
+	}
+}
+
+// Measure - runs ffmpeg's ebur128 filter over the file
+func (f FFmpeg) Measure(ctx context.Context, pathToFile string) (*Measurement, error) {
+	cmd := f.ffmpeg(ctx,
+		"-v", "info",
+		"-nostdin",
+		"-y",
+		"-i", pathToFile,
+		"-vn",
+		"-af", "ebur128=peak=true:metadata=1,ametadata=mode=print:file=-",
+		"-f", "null",
+		"null",
+	)
+	filterOutput, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = filterOutput.Close() }()
+
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	frames, loudness, lastTPLR := parseFFmpegOutput(filterOutput)
+	// the pipe is fully drained above; reap the process and surface any failure
+	// instead of leaking it and silently using partial output
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("ffmpeg analysis failed for %q: %w", pathToFile, err)
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no audio frames produced by ffmpeg for %q", pathToFile)
+	}
+
+	truePeak, truePeakDb, loudnessRange := parseTruePeakAndRange(lastTPLR)
+	return &Measurement{
+		Frames:     frames,
+		Integrated: loudness,
+		TruePeak:   TruePeakInfo{TruePeak: truePeak, TruePeakDb: truePeakDb, LoudnessRange: loudnessRange},
+	}, nil
+}
+
+// Onsets - decodes the file to mono PCM with ffmpeg and detects its onsets
+func (f FFmpeg) Onsets(ctx context.Context, pathToFile string) ([]float64, error) {
+	cmd := f.ffmpeg(ctx,
+		"-v", "error",
+		"-nostdin",
+		"-i", pathToFile,
+		"-vn",
+		"-ac", "1",
+		"-ar", strconv.Itoa(onsetSampleRate),
+		"-f", "f32le",
+		"-",
+	)
+	pcm, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = pcm.Close() }()
+
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	d := newOnsetDetector(onsetSampleRate, 1)
+	s, readErr := newPCMStream(pcm, onsetSampleRate, 1, sampleFormat{size: 4, float: true})
+	for readErr == nil {
+		var samples []float64
+		if samples, readErr = s.next(); readErr == nil {
+			d.Write(samples)
+		}
+	}
+	if readErr != io.EOF {
+		// close the pipe so ffmpeg fails on its next write instead of
+		// blocking, then reap it and report both errors
+		_ = pcm.Close()
+		readErr = fmt.Errorf("cannot read ffmpeg output for %q: %w", pathToFile, readErr)
+		if err := cmd.Wait(); err != nil {
+			return nil, errors.Join(readErr, fmt.Errorf("ffmpeg decoding failed for %q: %w", pathToFile, err))
+		}
+		return nil, readErr
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("ffmpeg decoding failed for %q: %w", pathToFile, err)
+	}
+	return d.Onsets(), nil
+}
+
+// ffmpeg returns an ffmpeg command with FFmpegArgs in front of args.
+func (f FFmpeg) ffmpeg(ctx context.Context, args ...string) *exec.Cmd {
+	return command(ctx, cmp.Or(f.FFmpegPath, defaultFFmpeg), append(slices.Clone(f.FFmpegArgs), args...)...)
+}
+
+// command returns an exec.Cmd which is killed once ctx is done. Wait gives up
+// on the output pipes shortly after the kill, so a stuck child can never block
+// the caller.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = killWaitDelay
+	return cmd
+}
@@ -0,0 +1,138 @@
+package cue
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type FFmpegSuite struct {
+	suite.Suite
+}
+
+func TestFFmpegSuite(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs shell scripts in place of ffmpeg and ffprobe")
+	}
+	suite.Run(t, &FFmpegSuite{})
+}
+
+// testScript writes a shell script with the given body and returns its path.
+func testScript(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "script")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// argsScript returns a script which saves its arguments, one per line, to
+// the returned file and then prints out.
+func argsScript(t *testing.T, out string) (script, args string) {
+	args = filepath.Join(t.TempDir(), "args")
+	script = testScript(t, `printf '%s\n' "$@" > `+args+"\ncat <<'EOF'\n"+out+"\nEOF")
+	return script, args
+}
+
+func (s *FFmpegSuite) readArgs(args string) []string {
+	b, err := os.ReadFile(args)
+	s.Require().NoError(err)
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func (s *FFmpegSuite) TestMeasure() {
+	script, args := argsScript(s.T(), `frame:0    pts:0       pts_time:0
+lavfi.r128.M=-70.000
+lavfi.r128.I=-70.000
+frame:1    pts:4800    pts_time:0.1
+lavfi.r128.M=-15.500
+lavfi.r128.I=-15.500
+frame:2    pts:9600    pts_time:0.2
+lavfi.r128.M=-14.000
+lavfi.r128.I=-14.700
+lavfi.r128.true_peaks_ch0=0.500
+lavfi.r128.true_peaks_ch1=0.800
+lavfi.r128.LRA=4.500`)
+	f := FFmpeg{FFmpegPath: script, FFmpegArgs: []string{"-threads", "1"}}
+
+	m, err := f.Measure(s.T().Context(), "a.flac")
+	s.Require().NoError(err)
+	s.Equal([]Frame{{0, -70}, {0.1, -15.5}, {0.2, -14}}, m.Frames)
+	s.Equal(-14.7, m.Integrated)
+	s.Equal(NewTruePeakInfo(0.8, 4.5), m.TruePeak)
+
+	got := s.readArgs(args)
+	s.Equal([]string{"-threads", "1"}, got[:2])
+	s.Less(2, slices.Index(got, "-i"))
+	s.Contains(got, "a.flac")
+
+	_, err = FFmpeg{FFmpegPath: testScript(s.T(), "exit 1")}.Measure(s.T().Context(), "a.flac")
+	s.ErrorContains(err, "ffmpeg analysis failed")
+	_, err = FFmpeg{FFmpegPath: testScript(s.T(), "exit 0")}.Measure(s.T().Context(), "a.flac")
+	s.ErrorContains(err, "no audio frames")
+}
+
+func (s *FFmpegSuite) TestOnsets() {
+	samples := clickTrack(onsetSampleRate, 2, 120, 0, true)
+	for i := range samples {
+		samples[i] = float64(float32(samples[i]))
+	}
+	pcm := filepath.Join(s.T().TempDir(), "a.pcm")
+	s.Require().NoError(os.WriteFile(pcm, encodeSamples(sampleFormat{size: 4, float: true}, samples), 0o644))
+	args := filepath.Join(s.T().TempDir(), "args")
+	script := testScript(s.T(), `printf '%s\n' "$@" > `+args+"\ncat "+pcm)
+
+	onsets, err := FFmpeg{FFmpegPath: script}.Onsets(s.T().Context(), "a.mp3")
+	s.Require().NoError(err)
+	s.Equal(onsetsOf(onsetSampleRate, samples), onsets)
+	got := s.readArgs(args)
+	s.Subset(got, []string{"a.mp3", "-ac", "1", "-ar", "22050", "f32le"})
+
+	_, err = FFmpeg{FFmpegPath: testScript(s.T(), "exit 1")}.Onsets(s.T().Context(), "a.mp3")
+	s.ErrorContains(err, "ffmpeg decoding failed")
+}
+
+func (s *FFmpegSuite) TestProbe() {
+	script, args := argsScript(s.T(), `{"streams":[{"codec_type":"video","duration":"1.0"},`+
+		`{"codec_type":"audio","tags":{"LIQ_CUE_IN":"0.50","Title":"Ogg"}}],`+
+		`"format":{"duration":"180.100","tags":{"title":"Format","liq_cue_out":"179.00"}}}`)
+	f := FFmpeg{FFprobePath: script, FFprobeArgs: []string{"-analyzeduration", "1M"}}
+
+	tags, err := f.Probe(s.T().Context(), "a.ogg")
+	s.Require().NoError(err)
+	s.Equal(map[string]string{
+		"duration":    "180.100",
+		"liq_cue_in":  "0.50",
+		"liq_cue_out": "179.00",
+		"title":       "Ogg",
+	}, tags)
+
+	got := s.readArgs(args)
+	s.Equal([]string{"-analyzeduration", "1M"}, got[:2])
+	s.Equal("a.ogg", got[len(got)-1])
+
+	_, err = FFmpeg{FFprobePath: testScript(s.T(), "echo '{'")}.Probe(s.T().Context(), "a.ogg")
+	s.ErrorContains(err, "cannot parse ffprobe output")
+}
+
+// TestWriteTags checks that formats without a native tag writer are remuxed
+// by the configured ffmpeg.
+func (s *FFmpegSuite) TestWriteTags() {
+	dir := s.T().TempDir()
+	path := filepath.Join(dir, "a.aiff")
+	s.Require().NoError(os.WriteFile(path, []byte("original"), 0o644))
+
+	// copies the input to the output, the last argument
+	script := testScript(s.T(), `for a; do out=$a; done; echo tagged > "$out"`)
+	opts := DefaultCalculatorOptions()
+	opts.FFmpeg.FFmpegPath = script
+	s.Require().NoError(NewCalculator(&opts).WriteTags(path, &Result{}))
+	b, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	s.Equal("tagged\n", string(b))
+}
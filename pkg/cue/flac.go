@@ -0,0 +1,212 @@
+package cue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+var flacID = []byte("fLaC")
+
+// FLAC metadata block types
+const (
+	flacStreamInfo    = 0
+	flacPadding       = 1
+	flacVorbisComment = 4
+
+	// largest metadata block payload (24 bit length field)
+	flacMaxBlockSize = 1<<24 - 1
+)
+
+// flacBlock - a FLAC metadata block; padding blocks are not kept
+type flacBlock struct {
+	typ  byte
+	data []byte
+}
+
+// writeFLACTags sets tags in the VORBIS_COMMENT block of a FLAC file. If the
+// metadata still fits in the space taken by the old comment and padding blocks,
+// the metadata is rewritten in place; otherwise the file is rewritten with
+// fresh padding, so the next update fits.
+func writeFLACTags(pathToFile string, tags map[string]string) error {
+	f, err := os.OpenFile(pathToFile, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	start, blocks, metaEnd, err := readFLACMetadata(f)
+	if err != nil {
+		return fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+
+	var vc *vorbisComment
+	idx := slices.IndexFunc(blocks, func(b flacBlock) bool { return b.typ == flacVorbisComment })
+	if idx >= 0 {
+		if vc, err = parseVorbisComment(blocks[idx].data); err != nil {
+			return fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+		}
+	} else {
+		// right after STREAMINFO, which must stay the first block
+		vc = &vorbisComment{vendor: vorbisVendor}
+		idx = 1
+		blocks = append(blocks[:1], append([]flacBlock{{typ: flacVorbisComment}}, blocks[1:]...)...)
+	}
+	vc.set(tags)
+	blocks[idx].data = vc.encode()
+	if len(blocks[idx].data) > flacMaxBlockSize {
+		return fmt.Errorf("tags too large for a FLAC metadata block in %q", pathToFile)
+	}
+
+	used := int64(0)
+	for _, b := range blocks {
+		used += 4 + int64(len(b.data))
+	}
+	avail := metaEnd - start - int64(len(flacID))
+	if free := avail - used; free == 0 || free >= 4 {
+		meta := encodeFLACMetadata(blocks, free)
+		_, err := f.WriteAt(meta, start+int64(len(flacID)))
+		return err
+	}
+	return rewriteFLAC(pathToFile, f, start, metaEnd, encodeFLACMetadata(blocks, 4+tagPadding))
+}
+
+// readFLACTags returns the fields of the VORBIS_COMMENT block of a FLAC file.
+func readFLACTags(pathToFile string) (map[string]string, error) {
+	f, err := os.Open(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	_, blocks, _, err := readFLACMetadata(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+	for _, b := range blocks {
+		if b.typ == flacVorbisComment {
+			vc, err := parseVorbisComment(b.data)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+			}
+			return vc.tags(), nil
+		}
+	}
+	return map[string]string{}, nil
+}
+
+// readFLACMetadata returns the offset of the "fLaC" marker (after an optional
+// ID3v2 tag), all non-padding metadata blocks and the offset of the first
+// audio frame.
+func readFLACMetadata(r io.ReadSeeker) (start int64, blocks []flacBlock, metaEnd int64, err error) {
+	var hdr [10]byte
+	if _, err := io.ReadFull(r, hdr[:4]); err != nil {
+		return 0, nil, 0, err
+	}
+	if bytes.Equal(hdr[:3], []byte("ID3")) {
+		if _, err := io.ReadFull(r, hdr[4:]); err != nil {
+			return 0, nil, 0, err
+		}
+		start = 10 + (int64(hdr[6])<<21 | int64(hdr[7])<<14 | int64(hdr[8])<<7 | int64(hdr[9]))
+		if hdr[5]&0x10 != 0 {
+			start += 10 // footer
+		}
+		if _, err := r.Seek(start, io.SeekStart); err != nil {
+			return 0, nil, 0, err
+		}
+		if _, err := io.ReadFull(r, hdr[:4]); err != nil {
+			return 0, nil, 0, err
+		}
+	}
+	if !bytes.Equal(hdr[:4], flacID) {
+		return 0, nil, 0, errors.New("not a FLAC file")
+	}
+
+	metaEnd = start + 4
+	for last := false; !last; {
+		var bh [4]byte
+		if _, err := io.ReadFull(r, bh[:]); err != nil {
+			return 0, nil, 0, err
+		}
+		last = bh[0]&0x80 != 0
+		typ := bh[0] & 0x7f
+		size := int64(bh[1])<<16 | int64(bh[2])<<8 | int64(bh[3])
+		metaEnd += 4 + size
+		if typ == flacPadding {
+			if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+				return 0, nil, 0, err
+			}
+			continue
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return 0, nil, 0, err
+		}
+		blocks = append(blocks, flacBlock{typ: typ, data: data})
+	}
+	if len(blocks) == 0 || blocks[0].typ != flacStreamInfo {
+		return 0, nil, 0, errors.New("missing FLAC STREAMINFO block")
+	}
+	return start, blocks, metaEnd, nil
+}
+
+// encodeFLACMetadata serialises blocks, followed by padding blocks taking up
+// free bytes (headers included) if free > 0. free must not be 1-3 bytes; it is
+// split into several blocks when it exceeds the maximum block size.
+func encodeFLACMetadata(blocks []flacBlock, free int64) []byte {
+	for free > 0 {
+		n := min(free, 4+flacMaxBlockSize)
+		if rest := free - n; rest > 0 && rest < 4 {
+			// leave room for the header of the next padding block
+			n -= 4
+		}
+		blocks = append(blocks, flacBlock{typ: flacPadding, data: make([]byte, n-4)})
+		free -= n
+	}
+	var buf bytes.Buffer
+	for i, b := range blocks {
+		typ := b.typ
+		if i == len(blocks)-1 {
+			typ |= 0x80
+		}
+		var bh [4]byte
+		binary.BigEndian.PutUint32(bh[:], uint32(len(b.data)))
+		bh[0] = typ
+		buf.Write(bh[:])
+		buf.Write(b.data)
+	}
+	return buf.Bytes()
+}
+
+// rewriteFLAC copies src into a temporary sibling file with the new metadata
+// (keeping a leading ID3v2 tag and all audio frames) and replaces the original.
+func rewriteFLAC(pathToFile string, src io.ReaderAt, start, metaEnd int64, meta []byte) error {
+	tmp, err := siblingTempFile(pathToFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, io.NewSectionReader(src, 0, start)); err == nil {
+		if _, err = dst.Write(flacID); err == nil {
+			if _, err = dst.Write(meta); err == nil {
+				_, err = io.Copy(dst, io.NewSectionReader(src, metaEnd, 1<<62))
+			}
+		}
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return replaceFile(pathToFile, tmp)
+}
@@ -0,0 +1,451 @@
+package cue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// FLAC channel assignments beyond the independent ones (0-7), which code one
+// channel of a stereo pair as the difference of both
+const (
+	flacLeftSide  = 8
+	flacSideRight = 9
+	flacMidSide   = 10
+)
+
+// bitReader - reads the big-endian bit fields of FLAC frames
+type bitReader struct {
+	r *bufio.Reader
+	// the low n bits of cache are still unread
+	cache uint64
+	n     uint
+}
+
+// read returns the next n (at most 56) bits.
+func (b *bitReader) read(n uint) (uint64, error) {
+	for b.n < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		b.cache = b.cache<<8 | uint64(c)
+		b.n += 8
+	}
+	b.n -= n
+	return b.cache >> b.n & (1<<n - 1), nil
+}
+
+// signed returns the next n bits as a two's complement number.
+func (b *bitReader) signed(n uint) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := b.read(n)
+	return int64(v<<(64-n)) >> (64 - n), err
+}
+
+// unary returns the number of zero bits before the next one bit.
+func (b *bitReader) unary() (uint64, error) {
+	var zeros uint64
+	for {
+		if b.n == 0 {
+			if _, err := b.read(8); err != nil {
+				return 0, err
+			}
+			b.n = 8
+		}
+		rest := b.cache & (1<<b.n - 1)
+		if rest == 0 {
+			zeros += uint64(b.n)
+			b.n = 0
+			continue
+		}
+		lead := uint(bits.LeadingZeros64(rest)) - (64 - b.n)
+		b.n -= lead + 1
+		return zeros + uint64(lead), nil
+	}
+}
+
+// align skips to the next byte boundary.
+func (b *bitReader) align() {
+	b.n -= b.n % 8
+}
+
+// flacDecoder - decodes the frames of a FLAC stream
+type flacDecoder struct {
+	bits       bitReader
+	sampleRate int
+	channels   int
+	bps        uint
+	// total is the number of sample frames from STREAMINFO, 0 if unknown
+	total   int64
+	decoded int64
+	// decoded samples of the current frame, per channel
+	samples [][]int64
+	out     []float64
+}
+
+// openFLAC returns the audio of a FLAC file.
+func openFLAC(r pcmFile) (*pcmStream, error) {
+	_, blocks, metaEnd, err := readFLACMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	info := blocks[0].data
+	if len(info) < 18 {
+		return nil, errors.New("truncated FLAC STREAMINFO block")
+	}
+	v := binary.BigEndian.Uint64(info[10:18])
+	d := &flacDecoder{
+		sampleRate: int(v >> 44),
+		channels:   int(v>>41&0x7) + 1,
+		bps:        uint(v>>36&0x1f) + 1,
+		total:      int64(v & (1<<36 - 1)),
+	}
+	if d.sampleRate == 0 {
+		return nil, errors.New("invalid FLAC sample rate")
+	}
+	if _, err := r.Seek(metaEnd, io.SeekStart); err != nil {
+		return nil, err
+	}
+	d.bits.r = bufio.NewReaderSize(r, 1<<16)
+	d.samples = make([][]int64, d.channels)
+	return &pcmStream{sampleRate: d.sampleRate, channels: d.channels, frames: d.total, next: d.next}, nil
+}
+
+// next decodes the next frame into interleaved samples.
+func (d *flacDecoder) next() ([]float64, error) {
+	if d.total > 0 && d.decoded >= d.total {
+		return nil, io.EOF
+	}
+	if _, err := d.bits.r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+	n, err := d.frame()
+	if err != nil {
+		return nil, err
+	}
+	if d.total > 0 {
+		n = int(min(int64(n), d.total-d.decoded))
+	}
+	d.decoded += int64(n)
+
+	scale := 1 / float64(int64(1)<<(d.bps-1))
+	out := d.out[:0]
+	for i := range n {
+		for ch := range d.channels {
+			out = append(out, float64(d.samples[ch][i])*scale)
+		}
+	}
+	d.out = out
+	return out, nil
+}
+
+// frame decodes one frame into d.samples and returns its block size.
+func (d *flacDecoder) frame() (int, error) {
+	b := &d.bits
+	sync, err := b.read(16)
+	if err != nil {
+		return 0, err
+	}
+	if sync>>2 != 0x3ffe {
+		return 0, fmt.Errorf("lost FLAC frame sync after %d samples", d.decoded)
+	}
+	hdr, err := b.read(16)
+	if err != nil {
+		return 0, err
+	}
+	blockCode, rateCode := hdr>>12, hdr>>8&0xf
+	assignment, sizeCode := int(hdr>>4&0xf), hdr>>1&0x7
+
+	// the frame or sample number, UTF-8 coded
+	first, err := b.read(8)
+	if err != nil {
+		return 0, err
+	}
+	for range max(0, bits.LeadingZeros8(^uint8(first))-1) {
+		if _, err := b.read(8); err != nil {
+			return 0, err
+		}
+	}
+
+	var blockSize int
+	switch {
+	case blockCode == 1:
+		blockSize = 192
+	case blockCode >= 2 && blockCode <= 5:
+		blockSize = 576 << (blockCode - 2)
+	case blockCode == 6 || blockCode == 7:
+		v, err := b.read(8 * uint(blockCode-5))
+		if err != nil {
+			return 0, err
+		}
+		blockSize = int(v) + 1
+	case blockCode >= 8:
+		blockSize = 256 << (blockCode - 8)
+	default:
+		return 0, errors.New("reserved FLAC block size")
+	}
+	// the frame's sample rate always equals the STREAMINFO one
+	switch rateCode {
+	case 12:
+		_, err = b.read(8)
+	case 13, 14:
+		_, err = b.read(16)
+	case 15:
+		err = errors.New("invalid FLAC sample rate")
+	}
+	if err != nil {
+		return 0, err
+	}
+	bps := d.bps
+	switch sizeCode {
+	case 0:
+	case 3:
+		return 0, errors.New("reserved FLAC sample size")
+	default:
+		bps = []uint{0, 8, 12, 0, 16, 20, 24, 32}[sizeCode]
+	}
+	if bps != d.bps {
+		return 0, fmt.Errorf("FLAC frame has %d bits per sample instead of %d", bps, d.bps)
+	}
+	channels := assignment + 1
+	if assignment >= flacLeftSide {
+		if assignment > flacMidSide {
+			return 0, errors.New("reserved FLAC channel assignment")
+		}
+		channels = 2
+	}
+	if channels != d.channels {
+		return 0, fmt.Errorf("FLAC frame has %d channels instead of %d", channels, d.channels)
+	}
+	if _, err := b.read(8); err != nil { // CRC-8
+		return 0, err
+	}
+
+	for ch := range channels {
+		if cap(d.samples[ch]) < blockSize {
+			d.samples[ch] = make([]int64, blockSize)
+		}
+		d.samples[ch] = d.samples[ch][:blockSize]
+		chBPS := bps
+		// the side channel needs one bit more
+		if (assignment == flacLeftSide || assignment == flacMidSide) && ch == 1 ||
+			assignment == flacSideRight && ch == 0 {
+			chBPS++
+		}
+		if err := d.subframe(d.samples[ch], chBPS); err != nil {
+			return 0, err
+		}
+	}
+
+	if channels == 2 {
+		left, right := d.samples[0], d.samples[1]
+		for i := range blockSize {
+			switch assignment {
+			case flacLeftSide:
+				right[i] = left[i] - right[i]
+			case flacSideRight:
+				left[i] += right[i]
+			case flacMidSide:
+				mid, side := left[i]<<1|right[i]&1, right[i]
+				left[i], right[i] = (mid+side)>>1, (mid-side)>>1
+			}
+		}
+	}
+
+	b.align()
+	if _, err := b.read(16); err != nil { // CRC-16
+		return 0, err
+	}
+	return blockSize, nil
+}
+
+// subframe decodes the samples of one channel.
+func (d *flacDecoder) subframe(s []int64, bps uint) error {
+	b := &d.bits
+	hdr, err := b.read(8)
+	if err != nil {
+		return err
+	}
+	if hdr&0x80 != 0 {
+		return errors.New("invalid FLAC subframe header")
+	}
+	typ := int(hdr >> 1 & 0x3f)
+	var wasted uint
+	if hdr&1 != 0 {
+		w, err := b.unary()
+		if err != nil {
+			return err
+		}
+		wasted = uint(w) + 1
+		if wasted >= bps {
+			return errors.New("invalid FLAC wasted bits")
+		}
+		bps -= wasted
+	}
+
+	switch {
+	case typ == 0: // constant
+		v, err := b.signed(bps)
+		if err != nil {
+			return err
+		}
+		for i := range s {
+			s[i] = v
+		}
+	case typ == 1: // verbatim
+		for i := range s {
+			if s[i], err = b.signed(bps); err != nil {
+				return err
+			}
+		}
+	case typ >= 8 && typ <= 12: // fixed predictor
+		order := typ - 8
+		if err := d.warmup(s, order, bps); err != nil {
+			return err
+		}
+		if err := d.residual(s, order); err != nil {
+			return err
+		}
+		for i := order; i < len(s); i++ {
+			switch order {
+			case 1:
+				s[i] += s[i-1]
+			case 2:
+				s[i] += 2*s[i-1] - s[i-2]
+			case 3:
+				s[i] += 3*s[i-1] - 3*s[i-2] + s[i-3]
+			case 4:
+				s[i] += 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4]
+			}
+		}
+	case typ >= 32: // linear predictor
+		order := typ - 31
+		if err := d.warmup(s, order, bps); err != nil {
+			return err
+		}
+		p, err := b.read(4)
+		if err != nil {
+			return err
+		}
+		if p == 0xf {
+			return errors.New("invalid FLAC predictor precision")
+		}
+		shift, err := b.signed(5)
+		if err != nil {
+			return err
+		}
+		if shift < 0 {
+			return errors.New("negative FLAC predictor shift")
+		}
+		coeffs := make([]int64, order)
+		for j := range coeffs {
+			if coeffs[j], err = b.signed(uint(p) + 1); err != nil {
+				return err
+			}
+		}
+		if err := d.residual(s, order); err != nil {
+			return err
+		}
+		for i := order; i < len(s); i++ {
+			var sum int64
+			for j, c := range coeffs {
+				sum += c * s[i-1-j]
+			}
+			s[i] += sum >> shift
+		}
+	default:
+		return fmt.Errorf("reserved FLAC subframe type %d", typ)
+	}
+
+	if wasted > 0 {
+		for i := range s {
+			s[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// warmup reads the first order samples of a predicted subframe verbatim.
+func (d *flacDecoder) warmup(s []int64, order int, bps uint) error {
+	if order > len(s) {
+		return errors.New("FLAC predictor order exceeds the block size")
+	}
+	for i := range order {
+		v, err := d.bits.signed(bps)
+		if err != nil {
+			return err
+		}
+		s[i] = v
+	}
+	return nil
+}
+
+// residual reads the Rice-coded prediction residual into s[order:].
+func (d *flacDecoder) residual(s []int64, order int) error {
+	b := &d.bits
+	method, err := b.read(2)
+	if err != nil {
+		return err
+	}
+	paramBits := uint(4)
+	switch method {
+	case 0:
+	case 1:
+		paramBits = 5
+	default:
+		return errors.New("reserved FLAC residual coding method")
+	}
+	escape := uint64(1)<<paramBits - 1
+	partitionOrder, err := b.read(4)
+	if err != nil {
+		return err
+	}
+	partitionLen := len(s) >> partitionOrder
+	if partitionLen<<partitionOrder != len(s) || partitionLen < order {
+		return errors.New("invalid FLAC residual partition order")
+	}
+
+	i := order
+	for p := range 1 << partitionOrder {
+		end := (p + 1) * partitionLen
+		param, err := b.read(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			n, err := b.read(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if s[i], err = b.signed(uint(n)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		k := uint(param)
+		for ; i < end; i++ {
+			q, err := b.unary()
+			if err != nil {
+				return err
+			}
+			r, err := b.read(k)
+			if err != nil {
+				return err
+			}
+			v := q<<k | r
+			s[i] = int64(v>>1) ^ -int64(v&1)
+		}
+	}
+	return nil
+}
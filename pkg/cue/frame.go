@@ -5,6 +5,8 @@ package cue
 // stays small and contiguous; "last frame only" values (integrated loudness and
 // the true-peak/LRA line) are returned separately by parseFFmpegOutput.
 type Frame struct {
-	PTSTime  float64
+	// PTSTime is the presentation time of the frame in seconds, 100 ms apart
+	PTSTime float64
+	// Loudness is the momentary (400 ms) loudness in LUFS
 	Loudness float64
 }
@@ -0,0 +1,153 @@
+package cue
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// audio content hashers that skip the tags, keyed by lower-cased file
+// extension; WriteTags leaves their hash unchanged. Other files are hashed
+// as a whole.
+var audioHashers = map[string]func(f *os.File, h hash.Hash) error{
+	".wav":  hashWAVAudio,
+	".mp3":  hashMP3Audio,
+	".flac": hashFLACAudio,
+	".ogg":  hashOggAudio,
+	".oga":  hashOggAudio,
+	".opus": hashOggAudio,
+	".m4a":  hashMP4Audio,
+	".mp4":  hashMP4Audio,
+}
+
+// contentHash returns a hex SHA-256 hash of the audio content of a file, so
+// that a renamed, moved or retagged file is still recognised.
+func contentHash(pathToFile string) (string, error) {
+	f, err := os.Open(pathToFile)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if hashAudio, ok := audioHashers[strings.ToLower(filepath.Ext(pathToFile))]; ok {
+		if err := hashAudio(f, h); err == nil {
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+		// not what the extension promises: hash the whole file
+		h.Reset()
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashWAVAudio hashes the format and data chunks.
+func hashWAVAudio(f *os.File, h hash.Hash) error {
+	chunks, err := readRIFFChunks(f)
+	if err != nil {
+		return err
+	}
+	for _, ch := range chunks {
+		if ch.id == "fmt " || ch.id == "data" {
+			if _, err := io.Copy(h, io.NewSectionReader(f, ch.offset, ch.size)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hashMP3Audio hashes everything between the ID3v2 tag and an ID3v1 tag.
+func hashMP3Audio(f *os.File, h hash.Hash) error {
+	t, err := readID3Tag(f)
+	if err != nil {
+		return err
+	}
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	var v1 [3]byte
+	if end-t.size >= 128 {
+		if _, err := f.ReadAt(v1[:], end-128); err != nil {
+			return err
+		}
+		if string(v1[:]) == "TAG" {
+			end -= 128
+		}
+	}
+	_, err = io.Copy(h, io.NewSectionReader(f, t.size, end-t.size))
+	return err
+}
+
+// hashFLACAudio hashes the STREAMINFO block and the audio frames.
+func hashFLACAudio(f *os.File, h hash.Hash) error {
+	_, blocks, metaEnd, err := readFLACMetadata(f)
+	if err != nil {
+		return err
+	}
+	h.Write(blocks[0].data)
+	_, err = io.Copy(h, io.NewSectionReader(f, metaEnd, 1<<62))
+	return err
+}
+
+// hashOggAudio hashes the identification header and the bodies of the audio
+// pages; page headers are skipped, since a rewritten comment header changes
+// the page numbers.
+func hashOggAudio(f *os.File, h hash.Hash) error {
+	r := bufio.NewReader(f)
+	first, err := readOggPage(r)
+	if err != nil {
+		return err
+	}
+	h.Write(first.body)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r.Reset(f)
+	if _, err := readOggHeaders(r); err != nil {
+		return err
+	}
+	for {
+		p, err := readOggPage(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		h.Write(p.body)
+	}
+}
+
+// hashMP4Audio hashes the media data atoms.
+func hashMP4Audio(f *os.File, h hash.Hash) error {
+	atoms, err := readMP4Atoms(f)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, a := range atoms {
+		if a.typ == "mdat" {
+			found = true
+			if _, err := io.Copy(h, io.NewSectionReader(f, a.offset, a.size)); err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return errors.New("missing MP4 mdat atom")
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package cue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+var id3Magic = []byte("ID3")
+
+const (
+	id3HeaderSize = 10
+
+	// header flags
+	id3Unsync         = 0x80
+	id3ExtendedHeader = 0x40
+	id3Footer         = 0x10
+
+	// TXXX text encodings
+	id3Latin1  = 0
+	id3UTF16   = 1
+	id3UTF16BE = 2
+	id3UTF8    = 3
+)
+
+// id3Frame - a raw ID3v2 frame, kept byte for byte unless it is replaced
+type id3Frame struct {
+	id    string
+	flags [2]byte
+	data  []byte
+}
+
+// id3Tag - an ID3v2.3 or ID3v2.4 tag
+type id3Tag struct {
+	version byte // major version, 3 or 4
+	frames  []id3Frame
+	// space taken by the tag in the file, header (and footer) included
+	size int64
+}
+
+// readID3Tag parses the ID3v2 tag at the start of r. A missing tag is not an
+// error: an empty ID3v2.3 tag with a zero size is returned.
+func readID3Tag(r io.Reader) (*id3Tag, error) {
+	var hdr [id3HeaderSize]byte
+	n, err := io.ReadFull(r, hdr[:])
+	if n < id3HeaderSize || !bytes.Equal(hdr[0:3], id3Magic) {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return &id3Tag{version: 3}, nil
+	}
+	t := &id3Tag{version: hdr[3]}
+	if t.version != 3 && t.version != 4 {
+		return nil, fmt.Errorf("unsupported ID3v2.%d tag", t.version)
+	}
+	flags := hdr[5]
+	body := make([]byte, unsyncsafe(hdr[6:10]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	t.size = id3HeaderSize + int64(len(body))
+	if flags&id3Footer != 0 {
+		t.size += id3HeaderSize
+	}
+	if flags&id3Unsync != 0 && t.version == 3 {
+		body = removeUnsync(body)
+	}
+	if flags&id3ExtendedHeader != 0 && len(body) >= 4 {
+		// v2.3 counts the size field itself, v2.4 does not
+		skip := int(binary.BigEndian.Uint32(body)) + 4
+		if t.version == 4 {
+			skip = unsyncsafe(body[0:4])
+		}
+		body = body[min(skip, len(body)):]
+	}
+
+	for len(body) >= id3HeaderSize && body[0] != 0 {
+		size := int(binary.BigEndian.Uint32(body[4:8]))
+		if t.version == 4 {
+			size = unsyncsafe(body[4:8])
+		}
+		if size > len(body)-id3HeaderSize {
+			return nil, errors.New("truncated ID3v2 frame")
+		}
+		t.frames = append(t.frames, id3Frame{
+			id:    string(body[0:4]),
+			flags: [2]byte{body[8], body[9]},
+			data:  body[id3HeaderSize : id3HeaderSize+size],
+		})
+		body = body[id3HeaderSize+size:]
+	}
+	return t, nil
+}
+
+// txxx returns the user-defined text frames by description. Frames that are
+// compressed, encrypted or unsynchronised are skipped.
+func (t *id3Tag) txxx() map[string]string {
+	tags := map[string]string{}
+	for _, f := range t.frames {
+		if f.id != "TXXX" || f.flags[1] != 0 {
+			continue
+		}
+		if desc, val, ok := decodeTXXX(f.data); ok {
+			tags[desc] = val
+		}
+	}
+	return tags
+}
+
+// set replaces the TXXX frames described like one of tags (case-insensitive)
+// with the new values, sorted by key; all other frames are kept in their order.
+func (t *id3Tag) set(tags map[string]string) {
+	replaced := make(map[string]bool, len(tags))
+	for key := range tags {
+		replaced[strings.ToUpper(key)] = true
+	}
+	frames := t.frames[:0]
+	for _, f := range t.frames {
+		if f.id == "TXXX" && f.flags[1] == 0 {
+			if desc, _, ok := decodeTXXX(f.data); ok && replaced[strings.ToUpper(desc)] {
+				continue
+			}
+		}
+		frames = append(frames, f)
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		frames = append(frames, id3Frame{id: "TXXX", data: t.encodeTXXX(key, tags[key])})
+	}
+	t.frames = frames
+}
+
+// encode serialises the tag with padding zero bytes after the frames. The
+// tag is written without unsynchronisation, extended header or footer.
+func (t *id3Tag) encode(padding int) []byte {
+	var frames bytes.Buffer
+	for _, f := range t.frames {
+		frames.WriteString(f.id)
+		if t.version == 4 {
+			frames.Write(syncsafe(len(f.data)))
+		} else {
+			_ = binary.Write(&frames, binary.BigEndian, uint32(len(f.data)))
+		}
+		frames.Write(f.flags[:])
+		frames.Write(f.data)
+	}
+	tag := append([]byte{'I', 'D', '3', t.version, 0, 0}, syncsafe(frames.Len()+padding)...)
+	tag = append(tag, frames.Bytes()...)
+	return append(tag, make([]byte, padding)...)
+}
+
+// encodeTXXX builds a TXXX frame body: UTF-8 for ID3v2.4; ISO-8859-1 for
+// ID3v2.3 if possible, UTF-16 otherwise.
+func (t *id3Tag) encodeTXXX(desc, val string) []byte {
+	if t.version == 4 {
+		return append(append(append([]byte{id3UTF8}, desc...), 0), val...)
+	}
+	if latin1, ok := toLatin1(desc + "\x00" + val); ok {
+		return append([]byte{id3Latin1}, latin1...)
+	}
+	b := []byte{id3UTF16}
+	for _, s := range []string{desc, val} {
+		b = append(b, 0xff, 0xfe) // little-endian BOM
+		for _, u := range utf16.Encode([]rune(s)) {
+			b = binary.LittleEndian.AppendUint16(b, u)
+		}
+		if s == desc {
+			b = append(b, 0, 0)
+		}
+	}
+	return b
+}
+
+// decodeTXXX splits a TXXX frame body into its description and value.
+func decodeTXXX(data []byte) (desc, val string, ok bool) {
+	if len(data) < 1 {
+		return "", "", false
+	}
+	enc, data := data[0], data[1:]
+	switch enc {
+	case id3Latin1, id3UTF8:
+		d, v, found := bytes.Cut(data, []byte{0})
+		if !found {
+			return "", "", false
+		}
+		v = bytes.TrimRight(v, "\x00")
+		if enc == id3UTF8 {
+			return string(d), string(v), true
+		}
+		return fromLatin1(d), fromLatin1(v), true
+	case id3UTF16, id3UTF16BE:
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return decodeUTF16(data[:i], enc == id3UTF16BE), decodeUTF16(data[i+2:], enc == id3UTF16BE), true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// decodeUTF16 decodes an UTF-16 string, honouring a leading byte order mark
+// and dropping trailing NULs.
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xff && b[1] == 0xfe:
+			bigEndian, b = false, b[2:]
+		case b[0] == 0xfe && b[1] == 0xff:
+			bigEndian, b = true, b[2:]
+		}
+	}
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		if bigEndian {
+			u = append(u, binary.BigEndian.Uint16(b[i:]))
+		} else {
+			u = append(u, binary.LittleEndian.Uint16(b[i:]))
+		}
+	}
+	for len(u) > 0 && u[len(u)-1] == 0 {
+		u = u[:len(u)-1]
+	}
+	return string(utf16.Decode(u))
+}
+
+func toLatin1(s string) ([]byte, bool) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return nil, false
+		}
+		b = append(b, byte(r))
+	}
+	return b, true
+}
+
+func fromLatin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// removeUnsync reverses ID3v2 unsynchronisation (0xff 0x00 -> 0xff).
+func removeUnsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xff && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
+
+// syncsafe encodes n as an ID3v2 28-bit syncsafe integer.
+func syncsafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7f, byte(n>>14) & 0x7f, byte(n>>7) & 0x7f, byte(n) & 0x7f}
+}
+
+// unsyncsafe decodes an ID3v2 28-bit syncsafe integer.
+func unsyncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// readMP3Tags returns the TXXX frames of the ID3v2 tag of an MP3 file.
+func readMP3Tags(pathToFile string) (map[string]string, error) {
+	f, err := os.Open(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	t, err := readID3Tag(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+	return t.txxx(), nil
+}
+
+// writeMP3Tags sets tags as TXXX frames of the ID3v2 tag at the start of an
+// MP3 file, keeping all other frames. If the tag still fits in the space of
+// the old one, it is rewritten in place; otherwise the file is rewritten with
+// fresh padding, so the next update fits.
+func writeMP3Tags(pathToFile string, tags map[string]string) error {
+	f, err := os.OpenFile(pathToFile, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	t, err := readID3Tag(f)
+	if err != nil {
+		return fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+	t.set(tags)
+	tag := t.encode(0)
+	if free := t.size - int64(len(tag)); t.size > 0 && free >= 0 {
+		_, err := f.WriteAt(t.encode(int(free)), 0)
+		return err
+	}
+	return rewriteWithHeader(pathToFile, f, t.size, t.encode(tagPadding))
+}
+
+// rewriteWithHeader copies src from offset skip on into a temporary sibling
+// file, preceded by header, and replaces the original with it.
+func rewriteWithHeader(pathToFile string, src io.ReaderAt, skip int64, header []byte) error {
+	tmp, err := siblingTempFile(pathToFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = dst.Write(header); err == nil {
+		_, err = io.Copy(dst, io.NewSectionReader(src, skip, 1<<62))
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return replaceFile(pathToFile, tmp)
+}
@@ -0,0 +1,166 @@
+package cue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ID3Suite struct {
+	suite.Suite
+}
+
+func TestID3Suite(t *testing.T) {
+	suite.Run(t, &ID3Suite{})
+}
+
+// some bytes standing in for MPEG audio frames
+var mp3Frames = bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x64, 0, 0, 0, 0}, 64)
+
+// writeTestMP3 creates an MP3 with an ID3v2.3 tag holding a title, a cover
+// image and a TXXX frame, followed by 256 bytes of padding.
+func (s *ID3Suite) writeTestMP3(path string) (apic []byte) {
+	apic = append([]byte("\x00image/jpeg\x00\x03\x00"), bytes.Repeat([]byte{0xd8}, 300)...)
+	t := &id3Tag{version: 3, frames: []id3Frame{
+		{id: "TIT2", data: []byte("\x00Title")},
+		{id: "APIC", data: apic},
+	}}
+	t.set(map[string]string{"liq_cue_in": "9.900"})
+	s.Require().NoError(os.WriteFile(path, append(t.encode(256), mp3Frames...), 0o644))
+	return apic
+}
+
+func (s *ID3Suite) TestWriteMP3Tags() {
+	path := filepath.Join(s.T().TempDir(), "a.mp3")
+	apic := s.writeTestMP3(path)
+	info, err := os.Stat(path)
+	s.Require().NoError(err)
+
+	// fits into the padding: written in place
+	s.Require().NoError(writeMP3Tags(path, map[string]string{"LIQ_CUE_IN": "1.200", "REPLAYGAIN_TRACK_GAIN": "-3.10 dB"}))
+	t := s.readTag(path, info.Size())
+	s.Equal(map[string]string{"LIQ_CUE_IN": "1.200", "REPLAYGAIN_TRACK_GAIN": "-3.10 dB"}, t.txxx())
+	s.Equal("TIT2", t.frames[0].id)
+	s.Equal("APIC", t.frames[1].id)
+	s.Equal(apic, t.frames[1].data)
+
+	// too large for the padding: rewritten with fresh padding
+	big := map[string]string{"liq_comment": string(bytes.Repeat([]byte("x"), 1000))}
+	s.Require().NoError(writeMP3Tags(path, big))
+	tagged, err := os.Stat(path)
+	s.Require().NoError(err)
+	s.Greater(tagged.Size(), info.Size()+1000)
+	t = s.readTag(path, tagged.Size())
+	s.Len(t.txxx(), 3)
+	s.Equal(apic, t.frames[1].data)
+
+	tags, err := readMP3Tags(path)
+	s.Require().NoError(err)
+	s.Equal("1.200", tags["LIQ_CUE_IN"])
+}
+
+func (s *ID3Suite) TestWriteMP3TagsWithoutTag() {
+	path := filepath.Join(s.T().TempDir(), "a.mp3")
+	s.Require().NoError(os.WriteFile(path, mp3Frames, 0o644))
+
+	s.Require().NoError(writeMP3Tags(path, map[string]string{"liq_cue_out": "3.000"}))
+	raw, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	t := s.readTag(path, int64(len(raw)))
+	s.Equal(byte(3), t.version)
+	s.Equal(map[string]string{"liq_cue_out": "3.000"}, t.txxx())
+}
+
+// readTag parses the file's tag and checks that the audio frames follow it
+// unchanged and that the file has the expected size.
+func (s *ID3Suite) readTag(path string, size int64) *id3Tag {
+	raw, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	s.Require().Len(raw, int(size))
+	t, err := readID3Tag(bytes.NewReader(raw))
+	s.Require().NoError(err)
+	s.Equal(mp3Frames, raw[t.size:])
+	return t
+}
+
+func (s *ID3Suite) TestDecodeTXXX() {
+	utf16le := []byte{id3UTF16, 0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune("liq_cue_in")) {
+		utf16le = binary.LittleEndian.AppendUint16(utf16le, u)
+	}
+	utf16le = append(utf16le, 0, 0, 0xff, 0xfe, '1', 0, '.', 0, '5', 0)
+
+	tests := []struct {
+		title string
+		data  []byte
+		desc  string
+		val   string
+	}{
+		{"latin1", []byte("\x00liq_cue_in\x001.5\x00"), "liq_cue_in", "1.5"},
+		{"utf-8", []byte("\x03K\xc3\xbcnstler\x00B\xc3\xa4r"), "Künstler", "Bär"},
+		{"utf-16", utf16le, "liq_cue_in", "1.5"},
+		{"utf-16be", []byte("\x02\x00a\x00\x00\x00b"), "a", "b"},
+	}
+	for _, tc := range tests {
+		s.Run(tc.title, func() {
+			desc, val, ok := decodeTXXX(tc.data)
+			s.True(ok)
+			s.Equal(tc.desc, desc)
+			s.Equal(tc.val, val)
+		})
+	}
+
+	// non-latin1 values are written as UTF-16 to ID3v2.3 tags
+	t := &id3Tag{version: 3}
+	desc, val, ok := decodeTXXX(t.encodeTXXX("title", "Ωmega"))
+	s.True(ok)
+	s.Equal("title", desc)
+	s.Equal("Ωmega", val)
+}
+
+func (s *ID3Suite) TestReadUnsynchronised() {
+	// an 8 byte TXXX frame ending in 0xff, with a 0x00 inserted after it
+	body := append([]byte("TXXX\x00\x00\x00\x08\x00\x00"), "\x00liq_x\x00\xff\x00"...)
+	tag := append([]byte("ID3\x03\x00\x80"), syncsafe(len(body))...)
+	t, err := readID3Tag(bytes.NewReader(append(tag, body...)))
+	s.Require().NoError(err)
+	s.Require().Len(t.frames, 1)
+	s.Equal([]byte("\x00liq_x\x00\xff"), t.frames[0].data)
+}
+
+// TestCalcNativeTags checks that tags written by WriteTags are read back
+// without ffprobe, taking the cached fast path.
+func (s *ID3Suite) TestCalcNativeTags() {
+	path := filepath.Join(s.T().TempDir(), "a.mp3")
+	s.Require().NoError(os.WriteFile(path, mp3Frames, 0o644))
+
+	c := NewCalculator(nil)
+	c.writeTags, c.writeReplayGain = true, true
+	analysed := &Result{
+		Duration:          180.5,
+		CueIn:             1.2,
+		CueOut:            178.0,
+		CrossStartNext:    174.0,
+		Loudness:          "-14.000 LUFS",
+		LoudnessRange:     "6.000 LU",
+		Amplify:           "-4.000 dB",
+		AmplifyAdjustment: "0.000 dB",
+		ReferenceLoudness: "-18.000 LUFS",
+		TruePeak:          0.9,
+		TruePeakDb:        "-0.915 dBFS",
+		BlankSkip:         0.0,
+	}
+	s.Require().NoError(c.WriteTags(path, analysed))
+
+	res, err := c.Calc(path)
+	s.Require().NoError(err)
+	s.True(res.Cached())
+	s.InDelta(178.0, res.CueOut, 1e-9)
+	s.InDelta(4.0, res.CrossDuration, 1e-9)
+	s.Equal("-4.000 dB", res.Amplify)
+}
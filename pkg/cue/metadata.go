@@ -0,0 +1,86 @@
+package cue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// user-settable cue points (e.g. from the AzuraCast UI) that take precedence
+// over analysed values when present in the request metadata
+var overrideTags = []string{
+	"liq_cue_in",
+	"liq_cue_out",
+	"liq_cross_start_next",
+	"liq_intro_end",
+}
+
+// ParseMetadata - decodes a Liquidsoap JSON metadata object into a map with
+// lower-cased keys; numbers and booleans are converted to strings, nested
+// values are ignored
+func ParseMetadata(r io.Reader) (map[string]string, error) {
+	var raw map[string]any
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("cannot parse JSON metadata: %w", err)
+	}
+	meta := make(map[string]string, len(raw))
+	for key, val := range raw {
+		key = strings.ToLower(key)
+		switch v := val.(type) {
+		case string:
+			meta[key] = v
+		case float64:
+			meta[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			meta[key] = strconv.FormatBool(v)
+		}
+	}
+	return meta, nil
+}
+
+// applyOverrides replaces the analysed cue points in res with the user-set
+// ones from metadata. A moved cue-out keeps the analysed overlap length,
+// unless liq_cross_start_next is set explicitly as well.
+func applyOverrides(res *Result, metadata map[string]string) {
+	var vals [4]float64
+	var set [4]bool
+	for i, key := range overrideTags {
+		raw, ok := metadata[key]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "metadata override error: cannot parse %s=%q\n", key, raw)
+			continue
+		}
+		vals[i], set[i] = v, true
+	}
+	if set == [4]bool{} {
+		return
+	}
+	cueIn, cueOut, crossStartNext, introEnd := vals[0], vals[1], vals[2], vals[3]
+
+	if set[0] {
+		res.CueIn = cueIn
+	}
+	if set[1] {
+		overlap := res.CueOut - res.CrossStartNext
+		res.CueOut = cueOut
+		res.CrossStartNext = cueOut - overlap
+	}
+	if set[2] {
+		res.CrossStartNext = crossStartNext
+	}
+	if set[3] {
+		res.IntroEnd = introEnd
+	}
+	res.CrossStartNext = max(res.CueIn, min(res.CrossStartNext, res.CueOut))
+	res.IntroEnd = max(res.CueIn, min(res.IntroEnd, res.CrossStartNext))
+	res.CueDuration = res.CueOut - res.CueIn
+	res.CrossDuration = res.CueOut - res.CrossStartNext
+	res.FadeOut = min(res.FadeOut, res.CrossDuration)
+}
@@ -0,0 +1,70 @@
+package cue
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MetadataSuite struct {
+	suite.Suite
+}
+
+func TestMetadataSuite(t *testing.T) {
+	suite.Run(t, &MetadataSuite{})
+}
+
+func (s *MetadataSuite) TestParseMetadata() {
+	meta, err := ParseMetadata(strings.NewReader(`{"LIQ_CUE_IN": 2.5, "liq_cue_out": "180.0", "liq_gocue": false, "nested": {"a": 1}}`))
+	s.Require().NoError(err)
+	s.Equal(map[string]string{
+		"liq_cue_in":  "2.5",
+		"liq_cue_out": "180.0",
+		"liq_gocue":   "false",
+	}, meta)
+
+	_, err = ParseMetadata(strings.NewReader(`[1, 2]`))
+	s.Error(err)
+}
+
+func (s *MetadataSuite) TestApplyOverrides() {
+	analysed := func() *Result {
+		return &Result{CueIn: 0.5, IntroEnd: 10.0, CueOut: 200.0, CrossStartNext: 195.0, CueDuration: 199.5, CrossDuration: 5.0, FadeOut: 2.5}
+	}
+	tests := []struct {
+		title    string
+		meta     map[string]string
+		cueIn    float64
+		cueOut   float64
+		crossing float64
+	}{
+		{"no overrides", map[string]string{"title": "x"}, 0.5, 200.0, 195.0},
+		{"cue-in only", map[string]string{"liq_cue_in": "2"}, 2.0, 200.0, 195.0},
+		{"cue-out keeps the overlap", map[string]string{"liq_cue_out": "150"}, 0.5, 150.0, 145.0},
+		{"explicit crossing wins", map[string]string{"liq_cue_out": "150", "liq_cross_start_next": "140"}, 0.5, 150.0, 140.0},
+		{"crossing is clamped to cue-out", map[string]string{"liq_cross_start_next": "210"}, 0.5, 200.0, 200.0},
+		{"invalid values are ignored", map[string]string{"liq_cue_in": ""}, 0.5, 200.0, 195.0},
+	}
+	for _, tc := range tests {
+		s.Run(tc.title, func() {
+			res := analysed()
+			applyOverrides(res, tc.meta)
+			s.InDelta(tc.cueIn, res.CueIn, 1e-9)
+			s.InDelta(tc.cueOut, res.CueOut, 1e-9)
+			s.InDelta(tc.crossing, res.CrossStartNext, 1e-9)
+			s.InDelta(tc.cueOut-tc.cueIn, res.CueDuration, 1e-9)
+			s.InDelta(tc.cueOut-tc.crossing, res.CrossDuration, 1e-9)
+			s.LessOrEqual(res.FadeOut, res.CrossDuration)
+			s.LessOrEqual(res.CueIn, res.IntroEnd)
+		})
+	}
+
+	res := analysed()
+	applyOverrides(res, map[string]string{"liq_intro_end": "20"})
+	s.InDelta(20.0, res.IntroEnd, 1e-9)
+	// a later cue-in moves the intro end along
+	res = analysed()
+	applyOverrides(res, map[string]string{"liq_cue_in": "15"})
+	s.InDelta(15.0, res.IntroEnd, 1e-9)
+}
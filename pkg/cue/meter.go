@@ -0,0 +1,340 @@
+package cue
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+const (
+	// block lengths in 100 ms steps: momentary loudness and gating blocks span
+	// 400 ms, short-term loudness 3 s
+	momentarySteps = 4
+	shortTermSteps = 30
+	// absolute gate of the integrated loudness and the loudness range
+	absoluteGateLUFS = -70.0
+	// relative gates below the absolute-gated mean
+	integratedGateLU = -10.0
+	rangeGateLU      = -20.0
+	// FIR length of the true peak oversampling filter
+	truePeakTaps = 49
+)
+
+// biquad - a second order IIR filter section
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+// biquadState - the delay line of a biquad, in transposed direct form II
+type biquadState struct {
+	z1, z2 float64
+}
+
+func (f *biquad) process(s *biquadState, x float64) float64 {
+	y := f.b0*x + s.z1
+	s.z1 = f.b1*x - f.a1*y + s.z2
+	s.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting returns the two stages of the BS.1770 K-weighting filter, a high
+// shelf modelling the head and a high-pass (RLB weighting), for any sample
+// rate; at 48 kHz they match the coefficients given in the standard.
+func kWeighting(sampleRate int) (shelf, highpass biquad) {
+	fs := float64(sampleRate)
+
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / fs)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf = biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / fs)
+	a0 = 1 + k/q + k*k
+	highpass = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highpass
+}
+
+// channelWeights returns the BS.1770 channel weights for the usual channel
+// orders: 5.0 (L R C Ls Rs) and 5.1 (L R C LFE Ls Rs) weight the surround
+// channels by 1.41 and leave out the LFE; all other layouts weight every
+// channel equally.
+func channelWeights(channels int) []float64 {
+	switch channels {
+	case 5:
+		return []float64{1, 1, 1, 1.41, 1.41}
+	case 6:
+		return []float64{1, 1, 1, 0, 1.41, 1.41}
+	}
+	w := make([]float64, channels)
+	for i := range w {
+		w[i] = 1
+	}
+	return w
+}
+
+// Meter - an EBU R128 loudness meter following ITU-R BS.1770-4 and EBU Tech
+// 3341/3342, measuring the same values as ffmpeg's ebur128 filter from PCM
+// samples: the momentary loudness every 100 ms, the integrated loudness, the
+// loudness range and the true peak.
+type Meter struct {
+	sampleRate int
+	channels   int
+	weights    []float64
+
+	shelf, highpass biquad
+	filters         []biquadState // two per channel
+	// samples of an incomplete sample frame from the last Write
+	partial []float64
+
+	// sample frames written so far; the 100 ms steps, like the frames of
+	// ffmpeg's ebur128 filter, end at whole sample frames counted from the
+	// start, so they do not drift for rates not divisible by 10
+	samples int64
+	// samples and weighted energy of the current step
+	pos    int
+	energy float64
+	// weighted energy sums of the last shortTermSteps steps, a ring buffer
+	recent []float64
+	steps  int
+
+	// mean energies of all 400 ms gating blocks and 3 s short-term blocks
+	blocks    []float64
+	shortTerm []float64
+	frames    []Frame
+
+	peak *truePeakMeter
+}
+
+// NewMeter - returns a meter for interleaved samples of the given format
+func NewMeter(sampleRate, channels int) (*Meter, error) {
+	if sampleRate < 10 || channels < 1 {
+		return nil, fmt.Errorf("unsupported audio format: %d Hz, %d channels", sampleRate, channels)
+	}
+	m := &Meter{
+		sampleRate: sampleRate,
+		channels:   channels,
+		weights:    channelWeights(channels),
+		filters:    make([]biquadState, 2*channels),
+		recent:     make([]float64, shortTermSteps),
+		frames:     make([]Frame, 0, initialFrameCapacity),
+		peak:       newTruePeakMeter(sampleRate, channels),
+	}
+	m.shelf, m.highpass = kWeighting(sampleRate)
+	return m, nil
+}
+
+// Write - measures interleaved samples in the range [-1, 1]; the samples of
+// one sample frame may be split across calls
+func (m *Meter) Write(samples []float64) {
+	if len(m.partial) > 0 {
+		n := min(m.channels-len(m.partial), len(samples))
+		m.partial = append(m.partial, samples[:n]...)
+		samples = samples[n:]
+		if len(m.partial) < m.channels {
+			return
+		}
+		m.writeFrame(m.partial)
+		m.partial = m.partial[:0]
+	}
+	for len(samples) >= m.channels {
+		m.writeFrame(samples[:m.channels])
+		samples = samples[m.channels:]
+	}
+	m.partial = append(m.partial, samples...)
+}
+
+// writeFrame measures one sample of every channel.
+func (m *Meter) writeFrame(frame []float64) {
+	for ch, x := range frame {
+		if m.weights[ch] == 0 {
+			continue
+		}
+		y := m.shelf.process(&m.filters[2*ch], x)
+		y = m.highpass.process(&m.filters[2*ch+1], y)
+		m.energy += m.weights[ch] * y * y
+	}
+	m.peak.write(frame)
+	m.pos++
+	m.samples++
+	if m.samples == int64(m.steps+1)*int64(m.sampleRate)/10 {
+		m.endStep()
+	}
+}
+
+// endStep closes the current 100 ms step: its frame carries the momentary
+// loudness of the 400 ms ending with it, which is zero-padded for the first
+// steps as in ffmpeg.
+func (m *Meter) endStep() {
+	momentary := m.windowEnergy(momentarySteps)
+	m.frames = append(m.frames, Frame{
+		PTSTime:  float64(m.samples-int64(m.pos)) / float64(m.sampleRate),
+		Loudness: energyLoudness(momentary),
+	})
+	if m.steps+1 >= momentarySteps {
+		m.blocks = append(m.blocks, momentary)
+	}
+	if m.steps+1 >= shortTermSteps {
+		m.shortTerm = append(m.shortTerm, m.windowEnergy(shortTermSteps))
+	}
+	m.recent[m.steps%shortTermSteps] = m.energy
+	m.steps++
+	m.pos, m.energy = 0, 0
+}
+
+// windowEnergy returns the mean energy of the current step and the n-1
+// steps before it, over n times 100 ms.
+func (m *Meter) windowEnergy(n int) float64 {
+	sum := m.energy
+	for i := 1; i < n && i <= m.steps; i++ {
+		sum += m.recent[(m.steps-i)%shortTermSteps]
+	}
+	return sum / (float64(n*m.sampleRate) / 10)
+}
+
+// Measurement - ends the measurement and returns its result; the meter must
+// not be written to afterwards
+func (m *Meter) Measurement() *Measurement {
+	if m.pos > 0 {
+		m.endStep()
+	}
+	return &Measurement{
+		Frames:     m.frames,
+		Integrated: m.integrated(),
+		TruePeak:   NewTruePeakInfo(m.peak.peak, m.loudnessRange()),
+	}
+}
+
+// integrated returns the gated mean loudness of all 400 ms blocks, or the
+// absolute gate if no block passes, as ffmpeg reports it.
+func (m *Meter) integrated() float64 {
+	mean, ok := gatedMean(m.blocks, integratedGateLU)
+	if !ok {
+		return absoluteGateLUFS
+	}
+	return energyLoudness(mean)
+}
+
+// loudnessRange returns the difference between the 95th and the 10th
+// percentile of the gated short-term loudness (EBU Tech 3342).
+func (m *Meter) loudnessRange() float64 {
+	mean, ok := gatedMean(m.shortTerm, 0)
+	if !ok {
+		return 0
+	}
+	gate := mean * math.Pow(10, rangeGateLU/10)
+	var loudness []float64
+	for _, e := range m.shortTerm {
+		if e > gate && e > absoluteGateEnergy() {
+			loudness = append(loudness, energyLoudness(e))
+		}
+	}
+	if len(loudness) == 0 {
+		return 0
+	}
+	slices.Sort(loudness)
+	percentile := func(p float64) float64 {
+		return loudness[int(math.Round(float64(len(loudness)-1)*p))]
+	}
+	return percentile(0.95) - percentile(0.10)
+}
+
+// gatedMean returns the mean of the energies above the absolute gate and, for
+// a non-zero relativeLU, above the relative gate below that mean.
+func gatedMean(energies []float64, relativeLU float64) (float64, bool) {
+	mean := func(gate float64) (float64, bool) {
+		var sum float64
+		var n int
+		for _, e := range energies {
+			if e > gate {
+				sum += e
+				n++
+			}
+		}
+		if n == 0 {
+			return 0, false
+		}
+		return sum / float64(n), true
+	}
+	abs, ok := mean(absoluteGateEnergy())
+	if !ok || relativeLU == 0 {
+		return abs, ok
+	}
+	return mean(max(abs*math.Pow(10, relativeLU/10), absoluteGateEnergy()))
+}
+
+func absoluteGateEnergy() float64 {
+	return math.Pow(10, (absoluteGateLUFS+0.691)/10)
+}
+
+// energyLoudness converts a mean weighted energy into LUFS.
+func energyLoudness(e float64) float64 {
+	return -0.691 + 10*math.Log10(e)
+}
+
+// truePeakMeter - finds the true peak of a signal by oversampling it 4 times
+// (2 times from 96 kHz, not at all from 192 kHz) with a windowed-sinc
+// interpolation filter, as recommended by BS.1770-4 Annex 2
+type truePeakMeter struct {
+	// phases[p] holds the taps producing the p-th interpolated sample
+	phases  [][]float64
+	history [][]float64 // per channel, newest sample first
+	// linear true peak of all channels
+	peak float64
+}
+
+func newTruePeakMeter(sampleRate, channels int) *truePeakMeter {
+	factor := 4
+	switch {
+	case sampleRate >= 192000:
+		factor = 1
+	case sampleRate >= 96000:
+		factor = 2
+	}
+	t := &truePeakMeter{phases: make([][]float64, factor)}
+	for j := range truePeakTaps {
+		// Hann-windowed sinc, cutting off at the original Nyquist frequency
+		x := float64(j-(truePeakTaps-1)/2) / float64(factor)
+		c := 1.0
+		if x != 0 {
+			c = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		c *= 0.5 * (1 - math.Cos(2*math.Pi*float64(j)/float64(truePeakTaps-1)))
+		t.phases[j%factor] = append(t.phases[j%factor], c)
+	}
+	taps := len(t.phases[0])
+	t.history = make([][]float64, channels)
+	for ch := range t.history {
+		t.history[ch] = make([]float64, taps)
+	}
+	return t
+}
+
+func (t *truePeakMeter) write(frame []float64) {
+	for ch, x := range frame {
+		h := t.history[ch]
+		copy(h[1:], h)
+		h[0] = x
+		for _, taps := range t.phases {
+			var y float64
+			for i, c := range taps {
+				y += c * h[i]
+			}
+			t.peak = max(t.peak, math.Abs(y))
+		}
+	}
+}
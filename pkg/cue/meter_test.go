@@ -0,0 +1,249 @@
+package cue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MeterSuite struct {
+	suite.Suite
+}
+
+func TestMeterSuite(t *testing.T) {
+	suite.Run(t, &MeterSuite{})
+}
+
+// tone - a sine of equal level on every channel
+type tone struct {
+	freq    float64
+	dbfs    float64
+	seconds float64
+	phase   float64
+}
+
+// tones returns the interleaved samples of the tones played one after another.
+func tones(sampleRate, channels int, ts ...tone) []float64 {
+	var out []float64
+	for _, t := range ts {
+		amp := math.Pow(10, t.dbfs/20)
+		for i := range int(t.seconds * float64(sampleRate)) {
+			x := amp * math.Sin(2*math.Pi*t.freq*float64(i)/float64(sampleRate)+t.phase)
+			for range channels {
+				out = append(out, x)
+			}
+		}
+	}
+	return out
+}
+
+// meter measures the samples, written in odd-sized chunks.
+func (s *MeterSuite) meter(sampleRate, channels int, samples []float64) *Measurement {
+	m, err := NewMeter(sampleRate, channels)
+	s.Require().NoError(err)
+	for len(samples) > 0 {
+		n := min(len(samples), 1001*channels+1)
+		m.Write(samples[:n])
+		samples = samples[n:]
+	}
+	return m.Measurement()
+}
+
+// TestSine checks EBU Tech 3341 cases 1 and 2: a stereo 1 kHz sine at
+// -23 or -33 dBFS reads as many LUFS. At 11025 Hz, the 100 ms steps are
+// 1102 or 1103 samples long.
+func (s *MeterSuite) TestSine() {
+	for _, rate := range []int{11025, 44100, 48000} {
+		for _, level := range []float64{-23, -33} {
+			m := s.meter(rate, 2, tones(rate, 2, tone{freq: 1000, dbfs: level, seconds: 20}))
+			s.InDelta(level, m.Integrated, 0.1)
+			s.Less(m.TruePeak.LoudnessRange, 0.1)
+			s.InDelta(level, m.TruePeak.TruePeakDb, 0.1)
+
+			s.Require().Len(m.Frames, 200)
+			for i, f := range m.Frames {
+				s.InDelta(float64(i)/10, f.PTSTime, 1/float64(rate))
+				if i >= momentarySteps-1 {
+					s.InDelta(level, f.Loudness, 0.1)
+				}
+			}
+			// zero-padded before the first 400 ms are complete
+			s.InDelta(level-3.01, m.Frames[1].Loudness, 0.1)
+		}
+	}
+}
+
+// TestGating checks EBU Tech 3341 cases 3 and 4: quieter parts below the
+// relative or absolute gate do not lower the integrated loudness.
+func (s *MeterSuite) TestGating() {
+	m := s.meter(48000, 2, tones(48000, 2,
+		tone{freq: 1000, dbfs: -36, seconds: 10},
+		tone{freq: 1000, dbfs: -23, seconds: 60},
+		tone{freq: 1000, dbfs: -36, seconds: 10},
+	))
+	s.InDelta(-23, m.Integrated, 0.1)
+
+	m = s.meter(48000, 2, tones(48000, 2,
+		tone{freq: 1000, dbfs: -72, seconds: 10},
+		tone{freq: 1000, dbfs: -36, seconds: 10},
+		tone{freq: 1000, dbfs: -23, seconds: 60},
+		tone{freq: 1000, dbfs: -36, seconds: 10},
+		tone{freq: 1000, dbfs: -72, seconds: 10},
+	))
+	s.InDelta(-23, m.Integrated, 0.1)
+}
+
+// TestLoudnessRange checks EBU Tech 3342 cases 1 to 3.
+func (s *MeterSuite) TestLoudnessRange() {
+	for _, tc := range []struct {
+		first, second, lra float64
+	}{
+		{-20, -30, 10},
+		{-20, -15, 5},
+		{-40, -20, 20},
+	} {
+		m := s.meter(48000, 2, tones(48000, 2,
+			tone{freq: 1000, dbfs: tc.first, seconds: 20},
+			tone{freq: 1000, dbfs: tc.second, seconds: 20},
+		))
+		s.InDelta(tc.lra, m.TruePeak.LoudnessRange, 1)
+	}
+}
+
+// TestTruePeak checks that inter-sample peaks are found: a quarter sample
+// rate sine shifted by 45° never has a sample above -3 dB of its peak.
+func (s *MeterSuite) TestTruePeak() {
+	samples := tones(48000, 2, tone{freq: 12000, dbfs: -6.02, seconds: 1, phase: math.Pi / 4})
+	var samplePeak float64
+	for _, x := range samples {
+		samplePeak = max(samplePeak, math.Abs(x))
+	}
+	s.InDelta(-9.03, 20*math.Log10(samplePeak), 0.01)
+
+	m := s.meter(48000, 2, samples)
+	s.InDelta(-6.02, m.TruePeak.TruePeakDb, 0.3)
+	s.InDelta(0.5, m.TruePeak.TruePeak, 0.02)
+
+	// from 96 kHz on, with 2x oversampling
+	m = s.meter(96000, 1, tones(96000, 1, tone{freq: 24000, dbfs: -6.02, seconds: 1, phase: math.Pi / 4}))
+	s.InDelta(-6.02, m.TruePeak.TruePeakDb, 0.5)
+}
+
+func (s *MeterSuite) TestSilence() {
+	m := s.meter(44100, 2, make([]float64, 2*44100*3/2))
+	s.Len(m.Frames, 15)
+	s.True(math.IsInf(m.Frames[14].Loudness, -1))
+	s.Equal(absoluteGateLUFS, m.Integrated)
+	s.Zero(m.TruePeak.LoudnessRange)
+	s.True(math.IsInf(m.TruePeak.TruePeakDb, -1))
+
+	_, err := NewMeter(0, 2)
+	s.Error(err)
+	_, err = NewMeter(44100, 0)
+	s.Error(err)
+}
+
+// TestSurround checks the BS.1770 channel weights: the LFE is left out and
+// the surround channels count 1.5 dB more.
+func (s *MeterSuite) TestSurround() {
+	mono := tones(48000, 1, tone{freq: 1000, dbfs: -23, seconds: 5})
+	surround := func(ch int) []float64 {
+		out := make([]float64, 6*len(mono))
+		for i, x := range mono {
+			out[6*i+ch] = x
+		}
+		return out
+	}
+	front := s.meter(48000, 6, surround(0))
+	s.InDelta(-26.0, front.Integrated, 0.1)
+	s.InDelta(front.Integrated+1.49, s.meter(48000, 6, surround(4)).Integrated, 0.05)
+	s.Equal(absoluteGateLUFS, s.meter(48000, 6, surround(3)).Integrated)
+}
+
+// writeFloatWAV writes interleaved samples as a 32-bit float WAV file.
+func writeFloatWAV(path string, sampleRate, channels int, samples []float64) error {
+	var data bytes.Buffer
+	for _, x := range samples {
+		_ = binary.Write(&data, binary.LittleEndian, float32(x))
+	}
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(36+data.Len()))
+	b.WriteString("WAVEfmt ")
+	for _, v := range []any{
+		uint32(16), uint16(3), uint16(channels), uint32(sampleRate),
+		uint32(sampleRate * channels * 4), uint16(channels * 4), uint16(32),
+	} {
+		_ = binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(data.Len()))
+	b.Write(data.Bytes())
+	return os.WriteFile(path, b.Bytes(), 0o644)
+}
+
+// TestMeterFFmpeg compares the meter with ffmpeg's ebur128 filter, on a test
+// signal and on the fixtures decoded by ffmpeg.
+func (s *MeterSuite) TestMeterFFmpeg() {
+	if _, err := exec.LookPath(defaultFFmpeg); err != nil {
+		s.T().Skip("ffmpeg not available")
+	}
+	dir := s.T().TempDir()
+	signal := tones(44100, 2,
+		tone{freq: 440, dbfs: -70, seconds: 2},
+		tone{freq: 440, dbfs: -18, seconds: 20},
+		tone{freq: 3000, dbfs: -9, seconds: 5, phase: 1},
+		tone{freq: 100, dbfs: -30, seconds: 8},
+	)
+	inputs := map[string][]float64{filepath.Join(dir, "signal.wav"): signal}
+
+	for _, fixture := range []string{"test_data/sample.ogg", "test_data/classic.wav"} {
+		if _, err := os.Stat(fixture); err != nil {
+			continue
+		}
+		out, err := exec.Command(defaultFFmpeg, "-v", "error", "-i", fixture,
+			"-ar", "44100", "-ac", "2", "-f", "f32le", "-").Output()
+		s.Require().NoError(err)
+		decoded := make([]float64, len(out)/4)
+		for i := range decoded {
+			decoded[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(out[4*i:])))
+		}
+		inputs[filepath.Join(dir, filepath.Base(fixture)+".wav")] = decoded
+	}
+
+	for path, samples := range inputs {
+		s.Run(filepath.Base(path), func() {
+			s.Require().NoError(writeFloatWAV(path, 44100, 2, samples))
+			want, err := FFmpeg{}.Measure(s.T().Context(), path)
+			s.Require().NoError(err)
+			got := s.meter(44100, 2, samples)
+
+			s.InDelta(want.Integrated, got.Integrated, 0.1)
+			s.InDelta(want.TruePeak.LoudnessRange, got.TruePeak.LoudnessRange, 0.2)
+			s.InDelta(want.TruePeak.TruePeakDb, got.TruePeak.TruePeakDb, 0.3)
+			s.Require().InDelta(len(want.Frames), len(got.Frames), 1)
+			for i := range min(len(want.Frames), len(got.Frames)) {
+				s.InDelta(want.Frames[i].PTSTime, got.Frames[i].PTSTime, 0.001)
+				if want.Frames[i].Loudness > absoluteGateLUFS {
+					s.InDelta(want.Frames[i].Loudness, got.Frames[i].Loudness, 0.1, "frame %d", i)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMeter(b *testing.B) {
+	samples := tones(44100, 2, tone{freq: 1000, dbfs: -18, seconds: 10})
+	b.ResetTimer()
+	for b.Loop() {
+		m, _ := NewMeter(44100, 2)
+		m.Write(samples)
+		_ = m.Measurement()
+	}
+}
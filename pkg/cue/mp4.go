@@ -0,0 +1,472 @@
+package cue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// mean of the iTunes-style freeform ("----") atoms holding custom tags
+	itunesMean = "com.apple.iTunes"
+	// freeform key of the iTunes Sound Check gain
+	itunNormKey = "iTunNORM"
+
+	mp4HeaderSize = 8
+	// data atom type of UTF-8 text
+	mp4UTF8 = 1
+)
+
+// atoms that contain other atoms on the way to the ilst and the chunk offsets
+var mp4Containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"udta": true, "meta": true, "ilst": true, "----": true,
+}
+
+// mp4Atom - an atom below moov; leaves keep their payload as is
+type mp4Atom struct {
+	typ  string
+	data []byte // payload of a leaf, or the full box header of meta
+	kids []*mp4Atom
+}
+
+// mp4TopAtom - location of a top-level atom
+type mp4TopAtom struct {
+	typ          string
+	offset, size int64
+}
+
+// readMP4Atoms lists the top-level atoms of an MP4 file.
+func readMP4Atoms(r io.ReadSeeker) ([]mp4TopAtom, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var atoms []mp4TopAtom
+	for offset := int64(0); offset+mp4HeaderSize <= end; {
+		var hdr [16]byte
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, hdr[:mp4HeaderSize]); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		switch size {
+		case 0: // up to the end of the file
+			size = end - offset
+		case 1: // 64 bit size
+			if _, err := io.ReadFull(r, hdr[8:16]); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+		}
+		if size < mp4HeaderSize || offset+size > end {
+			return nil, fmt.Errorf("invalid size of MP4 atom %q", hdr[4:8])
+		}
+		atoms = append(atoms, mp4TopAtom{typ: string(hdr[4:8]), offset: offset, size: size})
+		offset += size
+	}
+	if len(atoms) == 0 || atoms[0].typ != "ftyp" {
+		return nil, errors.New("not an MP4 file")
+	}
+	return atoms, nil
+}
+
+// parseMP4Atoms decodes the atoms in b, descending into mp4Containers.
+func parseMP4Atoms(b []byte) ([]*mp4Atom, error) {
+	var atoms []*mp4Atom
+	for len(b) > 0 {
+		if len(b) < mp4HeaderSize {
+			return nil, errors.New("truncated MP4 atom")
+		}
+		size, hdr := uint64(binary.BigEndian.Uint32(b[0:4])), mp4HeaderSize
+		switch {
+		case size == 0:
+			size = uint64(len(b))
+		case size == 1 && len(b) >= 16:
+			size, hdr = binary.BigEndian.Uint64(b[8:16]), 16
+		}
+		if size < uint64(hdr) || size > uint64(len(b)) {
+			return nil, fmt.Errorf("invalid size of MP4 atom %q", b[4:8])
+		}
+		a := &mp4Atom{typ: string(b[4:8])}
+		payload := b[hdr:size]
+		if mp4Containers[a.typ] {
+			// iTunes' meta is a full box, QuickTime's is not
+			if a.typ == "meta" && len(payload) >= 12 && string(payload[4:8]) != "hdlr" {
+				a.data, payload = payload[:4], payload[4:]
+			}
+			kids, err := parseMP4Atoms(payload)
+			if err != nil {
+				return nil, err
+			}
+			a.kids = kids
+			if a.kids == nil {
+				a.kids = []*mp4Atom{}
+			}
+		} else {
+			a.data = payload
+		}
+		atoms = append(atoms, a)
+		b = b[size:]
+	}
+	return atoms, nil
+}
+
+// size returns the encoded size of the atom.
+func (a *mp4Atom) size() int {
+	n := mp4HeaderSize + len(a.data)
+	for _, k := range a.kids {
+		n += k.size()
+	}
+	return n
+}
+
+// encode serialises the atom and its children.
+func (a *mp4Atom) encode() []byte {
+	b := make([]byte, 0, a.size())
+	b = binary.BigEndian.AppendUint32(b, uint32(a.size()))
+	b = append(append(b, a.typ...), a.data...)
+	for _, k := range a.kids {
+		b = append(b, k.encode()...)
+	}
+	return b
+}
+
+// child returns the first child of type typ, creating it (as a container,
+// or with data for a leaf) if create is set.
+func (a *mp4Atom) child(typ string, create bool, data []byte) *mp4Atom {
+	for _, k := range a.kids {
+		if k.typ == typ {
+			return k
+		}
+	}
+	if !create {
+		return nil
+	}
+	k := &mp4Atom{typ: typ, data: data, kids: []*mp4Atom{}}
+	a.kids = append(a.kids, k)
+	return k
+}
+
+// mp4ItemList returns the iTunes metadata list of moov, creating the udta/meta/ilst
+// path if create is set.
+func mp4ItemList(moov *mp4Atom, create bool) *mp4Atom {
+	udta := moov.child("udta", create, nil)
+	if udta == nil {
+		return nil
+	}
+	meta := udta.child("meta", create, []byte{0, 0, 0, 0})
+	if meta == nil {
+		return nil
+	}
+	if create && meta.child("hdlr", false, nil) == nil {
+		hdlr := &mp4Atom{typ: "hdlr", data: append(append(make([]byte, 8), "mdirappl"...), make([]byte, 9)...)}
+		meta.kids = append([]*mp4Atom{hdlr}, meta.kids...)
+	}
+	return meta.child("ilst", create, nil)
+}
+
+// freeform decodes a "----" item into its mean, name and first text value.
+func (a *mp4Atom) freeform() (mean, name, val string, ok bool) {
+	for _, k := range a.kids {
+		if len(k.data) < 4 {
+			continue
+		}
+		switch k.typ {
+		case "mean":
+			mean = string(k.data[4:])
+		case "name":
+			name = string(k.data[4:])
+		case "data":
+			if len(k.data) >= 8 && !ok {
+				val, ok = string(k.data[8:]), true
+			}
+		}
+	}
+	return mean, name, val, ok && mean != "" && name != ""
+}
+
+// newFreeform builds a "----" item holding a UTF-8 text value.
+func newFreeform(name, val string) *mp4Atom {
+	return &mp4Atom{typ: "----", kids: []*mp4Atom{
+		{typ: "mean", data: append([]byte{0, 0, 0, 0}, itunesMean...)},
+		{typ: "name", data: append([]byte{0, 0, 0, 0}, name...)},
+		{typ: "data", data: append([]byte{0, 0, 0, mp4UTF8, 0, 0, 0, 0}, val...)},
+	}}
+}
+
+// readMP4Tags returns the iTunes freeform tags of an MP4/M4A file. A Sound
+// Check (iTunNORM) value is also returned as liq_amplify, unless that is set.
+func readMP4Tags(pathToFile string) (map[string]string, error) {
+	f, err := os.Open(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	moov, _, err := readMP4Moov(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+	tags := map[string]string{}
+	if ilst := mp4ItemList(moov, false); ilst != nil {
+		for _, item := range ilst.kids {
+			if item.typ != "----" {
+				continue
+			}
+			if mean, name, val, ok := item.freeform(); ok && mean == itunesMean {
+				tags[name] = val
+			}
+		}
+	}
+	if norm, ok := tags[itunNormKey]; ok && tags["liq_amplify"] == "" {
+		if gain, err := parseSoundCheck(norm); err == nil {
+			tags["liq_amplify"] = fmt.Sprintf("%.3f dB", gain)
+		}
+	}
+	return tags, nil
+}
+
+// readMP4Moov parses the moov atom of an MP4 file and returns it with the
+// list of top-level atoms.
+func readMP4Moov(f io.ReadSeeker) (*mp4Atom, []mp4TopAtom, error) {
+	atoms, err := readMP4Atoms(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, a := range atoms {
+		if a.typ != "moov" {
+			continue
+		}
+		raw := make([]byte, a.size)
+		if _, err := f.Seek(a.offset, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		if _, err := io.ReadFull(f, raw); err != nil {
+			return nil, nil, err
+		}
+		moov, err := parseMP4Atoms(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		return moov[0], atoms, nil
+	}
+	return nil, nil, errors.New("missing MP4 moov atom")
+}
+
+// writeMP4Tags sets tags as iTunes freeform atoms of an MP4/M4A file, keeping
+// all other items, and stores liq_amplify as Sound Check (iTunNORM) as well.
+// The moov atom is rewritten in place if it fits into its old space plus a
+// following free atom, or if it is the last atom; otherwise the file is
+// rewritten with a padding free atom and the chunk offsets are adjusted.
+func writeMP4Tags(pathToFile string, tags map[string]string) error {
+	f, err := os.OpenFile(pathToFile, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	moov, atoms, err := readMP4Moov(f)
+	if err != nil {
+		return fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+	tags = withSoundCheck(tags)
+
+	ilst := mp4ItemList(moov, true)
+	items := ilst.kids[:0]
+	for _, item := range ilst.kids {
+		if _, name, _, ok := item.freeform(); ok && item.typ == "----" && hasKeyFold(tags, name) {
+			continue
+		}
+		items = append(items, item)
+	}
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		items = append(items, newFreeform(key, tags[key]))
+	}
+	ilst.kids = items
+
+	// the space the moov atom may take: its own plus any free atoms after it
+	i := 0
+	for atoms[i].typ != "moov" {
+		i++
+	}
+	start, avail := atoms[i].offset, atoms[i].size
+	j := i + 1
+	for ; j < len(atoms) && (atoms[j].typ == "free" || atoms[j].typ == "skip"); j++ {
+		avail += atoms[j].size
+	}
+	last := j == len(atoms)
+
+	size := int64(moov.size())
+	switch free := avail - size; {
+	case free == 0 || free >= mp4HeaderSize:
+		_, err := f.WriteAt(append(moov.encode(), freeAtom(free)...), start)
+		return err
+	case last:
+		if _, err := f.WriteAt(moov.encode(), start); err != nil {
+			return err
+		}
+		return f.Truncate(start + size)
+	}
+
+	// chunk offsets don't change the moov size, so the shift is known upfront
+	delta := size + mp4HeaderSize + tagPadding - avail
+	if err := shiftChunkOffsets(moov, start, delta); err != nil {
+		return fmt.Errorf("cannot rewrite %q: %w", pathToFile, err)
+	}
+	return rewriteMP4(pathToFile, f, start, start+avail, append(moov.encode(), freeAtom(mp4HeaderSize+tagPadding)...))
+}
+
+// freeAtom returns a free atom of size bytes, or nothing for size 0.
+func freeAtom(size int64) []byte {
+	if size == 0 {
+		return nil
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(size))
+	return append(append(b, "free"...), make([]byte, size-mp4HeaderSize)...)
+}
+
+// shiftChunkOffsets moves all chunk offsets pointing behind after by delta.
+func shiftChunkOffsets(a *mp4Atom, after, delta int64) error {
+	for _, k := range a.kids {
+		if err := shiftChunkOffsets(k, after, delta); err != nil {
+			return err
+		}
+	}
+	if (a.typ != "stco" && a.typ != "co64") || len(a.data) < 8 {
+		return nil
+	}
+	width := 4
+	if a.typ == "co64" {
+		width = 8
+	}
+	n := int(binary.BigEndian.Uint32(a.data[4:8]))
+	entries := a.data[8:]
+	if len(entries) < n*width {
+		return errors.New("truncated chunk offset table")
+	}
+	for i := range n {
+		e := entries[i*width:]
+		if width == 8 {
+			if off := int64(binary.BigEndian.Uint64(e)); off > after {
+				binary.BigEndian.PutUint64(e, uint64(off+delta))
+			}
+			continue
+		}
+		if off := int64(binary.BigEndian.Uint32(e)); off > after {
+			if off+delta > math.MaxUint32 {
+				return errors.New("chunk offset exceeds 32 bits")
+			}
+			binary.BigEndian.PutUint32(e, uint32(off+delta))
+		}
+	}
+	return nil
+}
+
+// rewriteMP4 copies src into a temporary sibling file, replacing the bytes
+// from start to end with moov, and replaces the original with it.
+func rewriteMP4(pathToFile string, src io.ReaderAt, start, end int64, moov []byte) error {
+	tmp, err := siblingTempFile(pathToFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, io.NewSectionReader(src, 0, start)); err == nil {
+		if _, err = dst.Write(moov); err == nil {
+			_, err = io.Copy(dst, io.NewSectionReader(src, end, 1<<62))
+		}
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return replaceFile(pathToFile, tmp)
+}
+
+// withSoundCheck returns tags plus an iTunNORM value derived from the track
+// gain (liq_amplify, or the ReplayGain gain) and peak, if present.
+func withSoundCheck(tags map[string]string) map[string]string {
+	value := func(keys ...string) (float64, bool) {
+		for _, key := range keys {
+			if raw, ok := tags[key]; ok {
+				if clean, err := takePureValue(key, raw); err == nil {
+					if v, err := strconv.ParseFloat(clean, 64); err == nil {
+						return v, true
+					}
+				}
+			}
+		}
+		return 0, false
+	}
+	gain, ok := value("liq_amplify", "replaygain_track_gain")
+	if !ok {
+		return tags
+	}
+	peak, _ := value("liq_true_peak", "replaygain_track_peak")
+	out := maps.Clone(tags)
+	out[itunNormKey] = soundCheck(gain, peak)
+	return out
+}
+
+// soundCheck encodes a gain in dB and a linear peak as an iTunNORM value: ten
+// hex numbers, of which the first two pairs hold the gain for a 1/1000 and a
+// 1/2500 reference, and the fourth pair the peak on a 16 bit scale.
+func soundCheck(gain, peak float64) string {
+	norm := func(ref float64) uint32 {
+		return uint32(min(max(math.Round(ref*math.Pow(10, -gain/10)), 1), math.MaxUint32))
+	}
+	p := uint32(min(max(math.Round(peak*32768), 0), math.MaxUint32))
+	vals := []uint32{norm(1000), norm(1000), norm(2500), norm(2500), 0, 0, p, p, 0, 0}
+	var b strings.Builder
+	for _, v := range vals {
+		fmt.Fprintf(&b, " %08X", v)
+	}
+	return b.String()
+}
+
+// parseSoundCheck decodes the gain in dB from an iTunNORM value, using the
+// larger of the two 1/1000 reference values (left and right channel).
+func parseSoundCheck(norm string) (float64, error) {
+	fields := strings.Fields(norm)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("invalid iTunNORM value %q", norm)
+	}
+	var ref uint64
+	for _, field := range fields[:2] {
+		v, err := strconv.ParseUint(field, 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid iTunNORM value %q", norm)
+		}
+		ref = max(ref, v)
+	}
+	if ref == 0 {
+		return 0, fmt.Errorf("invalid iTunNORM value %q", norm)
+	}
+	return -10 * math.Log10(float64(ref)/1000), nil
+}
+
+// hasKeyFold reports whether tags has key, ignoring case.
+func hasKeyFold(tags map[string]string, key string) bool {
+	for k := range tags {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
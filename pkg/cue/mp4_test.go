@@ -0,0 +1,162 @@
+package cue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MP4Suite struct {
+	suite.Suite
+}
+
+func TestMP4Suite(t *testing.T) {
+	suite.Run(t, &MP4Suite{})
+}
+
+// some bytes standing in for AAC frames
+var mp4Samples = bytes.Repeat([]byte("AAC!"), 64)
+
+func mp4Box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(mp4HeaderSize+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+// testMP4Moov builds a moov atom whose single chunk offset is samples.
+func testMP4Moov(samples uint32) []byte {
+	stco := binary.BigEndian.AppendUint32([]byte{0, 0, 0, 0, 0, 0, 0, 1}, samples)
+	stbl := mp4Box("stbl", mp4Box("stco", stco))
+	trak := mp4Box("trak", mp4Box("mdia", mp4Box("minf", stbl)))
+	return mp4Box("moov", mp4Box("mvhd", make([]byte, 100)), trak)
+}
+
+// testMP4File returns an M4A file with the moov atom in front of or behind
+// the media data.
+func testMP4File(moovFirst bool) []byte {
+	ftyp := mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00M4A isom"))
+	mdat := mp4Box("mdat", mp4Samples)
+	if moovFirst {
+		moovSize := len(testMP4Moov(0))
+		return bytes.Join([][]byte{ftyp, testMP4Moov(uint32(len(ftyp) + moovSize + mp4HeaderSize)), mdat}, nil)
+	}
+	return bytes.Join([][]byte{ftyp, mdat, testMP4Moov(uint32(len(ftyp) + mp4HeaderSize))}, nil)
+}
+
+func (s *MP4Suite) writeTestMP4(path string, moovFirst bool) {
+	s.Require().NoError(os.WriteFile(path, testMP4File(moovFirst), 0o644))
+}
+
+// checkSamples checks that the chunk offset still points at the media data
+// and returns the file size.
+func (s *MP4Suite) checkSamples(path string) int64 {
+	raw, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	f, err := os.Open(path)
+	s.Require().NoError(err)
+	defer func() { _ = f.Close() }()
+	moov, _, err := readMP4Moov(f)
+	s.Require().NoError(err)
+
+	stco := moov.child("trak", false, nil).child("mdia", false, nil).child("minf", false, nil).
+		child("stbl", false, nil).child("stco", false, nil)
+	s.Require().NotNil(stco)
+	off := int(binary.BigEndian.Uint32(stco.data[8:12]))
+	s.Require().LessOrEqual(off+len(mp4Samples), len(raw))
+	s.Equal(mp4Samples, raw[off:off+len(mp4Samples)])
+	return int64(len(raw))
+}
+
+func (s *MP4Suite) TestWriteMP4Tags() {
+	path := filepath.Join(s.T().TempDir(), "a.m4a")
+	s.writeTestMP4(path, true)
+
+	// no room in front of the media data: rewritten with padding
+	s.Require().NoError(writeMP4Tags(path, map[string]string{"liq_cue_in": "1.200", "liq_amplify": "-4.000 dB"}))
+	size := s.checkSamples(path)
+
+	// the next update fits into the padding
+	s.Require().NoError(writeMP4Tags(path, map[string]string{"LIQ_CUE_IN": "2.500", "liq_cue_out": "170.000"}))
+	s.Equal(size, s.checkSamples(path))
+
+	tags, err := readMP4Tags(path)
+	s.Require().NoError(err)
+	s.Equal("2.500", tags["LIQ_CUE_IN"])
+	s.NotContains(tags, "liq_cue_in")
+	s.Equal("170.000", tags["liq_cue_out"])
+	s.Equal("-4.000 dB", tags["liq_amplify"])
+	s.Contains(tags, itunNormKey)
+}
+
+func (s *MP4Suite) TestWriteMP4TagsMoovLast() {
+	path := filepath.Join(s.T().TempDir(), "a.m4a")
+	s.writeTestMP4(path, false)
+	before := s.checkSamples(path)
+
+	s.Require().NoError(writeMP4Tags(path, map[string]string{"liq_cue_in": "1.200"}))
+	s.Greater(s.checkSamples(path), before)
+
+	// a shorter value: moov shrinks and the file is truncated
+	s.Require().NoError(writeMP4Tags(path, map[string]string{"liq_cue_in": "1"}))
+	after := s.checkSamples(path)
+	tags, err := readMP4Tags(path)
+	s.Require().NoError(err)
+	s.Equal(map[string]string{"liq_cue_in": "1"}, tags)
+
+	raw, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	atoms, err := readMP4Atoms(bytes.NewReader(raw))
+	s.Require().NoError(err)
+	s.Equal("moov", atoms[len(atoms)-1].typ)
+	s.Equal(after, atoms[len(atoms)-1].offset+atoms[len(atoms)-1].size)
+}
+
+func (s *MP4Suite) TestSoundCheck() {
+	for _, gain := range []float64{-9.5, -4, 0, 3.25} {
+		got, err := parseSoundCheck(soundCheck(gain, 0.9))
+		s.Require().NoError(err)
+		s.InDelta(gain, got, 0.01)
+	}
+
+	// iTunes writes the gain of both channels; the louder one counts
+	got, err := parseSoundCheck(" 000003E8 00000FA0 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000")
+	s.Require().NoError(err)
+	s.InDelta(-6.02, got, 0.01)
+
+	_, err = parseSoundCheck("zz")
+	s.Error(err)
+}
+
+// TestCalcNativeTags checks that M4A tags written by WriteTags are read back
+// without ffprobe, taking the cached fast path.
+func (s *MP4Suite) TestCalcNativeTags() {
+	path := filepath.Join(s.T().TempDir(), "a.m4a")
+	s.writeTestMP4(path, true)
+
+	c := NewCalculator(nil)
+	c.writeTags, c.writeReplayGain = true, true
+	s.Require().NoError(c.WriteTags(path, &Result{
+		Duration:          180.5,
+		CueIn:             1.2,
+		CueOut:            178.0,
+		CrossStartNext:    174.0,
+		Loudness:          "-14.000 LUFS",
+		LoudnessRange:     "6.000 LU",
+		Amplify:           "-4.000 dB",
+		AmplifyAdjustment: "0.000 dB",
+		ReferenceLoudness: "-18.000 LUFS",
+		TruePeak:          0.9,
+		TruePeakDb:        "-0.915 dBFS",
+	}))
+	s.checkSamples(path)
+
+	res, err := c.Calc(path)
+	s.Require().NoError(err)
+	s.True(res.Cached())
+	s.InDelta(178.0, res.CueOut, 1e-9)
+	s.Equal("-4.000 dB", res.Amplify)
+}
@@ -0,0 +1,309 @@
+package cue
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sample frames decoded per read of uncompressed PCM
+const pcmReadFrames = 4096
+
+// pcmFile - the input of the native decoders
+type pcmFile interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// pcmStream - decoded audio, read as interleaved samples in the range [-1, 1]
+type pcmStream struct {
+	sampleRate int
+	channels   int
+	// frames is the number of sample frames, 0 if unknown
+	frames int64
+	// next returns the following samples, which stay valid until the next
+	// call, and io.EOF after the last ones
+	next func() ([]float64, error)
+}
+
+// nativeFormat - a container whose PCM audio and tags Native reads
+type nativeFormat struct {
+	open func(r pcmFile) (*pcmStream, error)
+	tags func(pathToFile string) (map[string]string, error)
+}
+
+// formats decoded by Native, keyed by lower-cased file extension
+var nativeFormats = map[string]nativeFormat{
+	".wav":  {open: openWAV, tags: readWAVTags},
+	".aif":  {open: openAIFF, tags: readAIFFTags},
+	".aiff": {open: openAIFF, tags: readAIFFTags},
+	".aifc": {open: openAIFF, tags: readAIFFTags},
+	".flac": {open: openFLAC, tags: readFLACTags},
+}
+
+// Native - a Prober, LoudnessSource and OnsetSource without external
+// programs: it decodes uncompressed WAV (PCM and float, WAVE_FORMAT_EXTENSIBLE
+// included), AIFF and AIFC, and FLAC files itself and measures them with a
+// Meter. Other formats and codecs (e.g. MP3 inside WAV) return an error.
+type Native struct {
+	// DetectOnsets makes Measure detect onsets along the way, filling in
+	// Measurement.Onsets for the beat detection
+	DetectOnsets bool
+}
+
+// Probe - reads the tags and the duration of the file from its headers
+func (Native) Probe(_ context.Context, pathToFile string) (map[string]string, error) {
+	format, err := nativeFormatOf(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	s, f, err := openPCM(format, pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	_ = f.Close()
+
+	raw, err := format.tags(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(raw)+1)
+	copyLower(tags, raw)
+	if s.frames > 0 {
+		tags["duration"] = strconv.FormatFloat(float64(s.frames)/float64(s.sampleRate), 'f', 6, 64)
+	}
+	return tags, nil
+}
+
+// Measure - decodes the file and measures it with a Meter
+func (n Native) Measure(ctx context.Context, pathToFile string) (*Measurement, error) {
+	var m *Meter
+	var d *onsetDetector
+	err := decode(ctx, pathToFile, func(s *pcmStream) error {
+		var err error
+		m, err = NewMeter(s.sampleRate, s.channels)
+		if n.DetectOnsets {
+			d = newOnsetDetector(s.sampleRate, s.channels)
+		}
+		return err
+	}, func(samples []float64) {
+		m.Write(samples)
+		if d != nil {
+			d.Write(samples)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := m.Measurement()
+	if len(res.Frames) == 0 {
+		return nil, fmt.Errorf("no audio frames decoded from %q", pathToFile)
+	}
+	if d != nil {
+		res.Onsets = d.Onsets()
+	}
+	return res, nil
+}
+
+// Onsets - decodes the file and detects its onsets
+func (Native) Onsets(ctx context.Context, pathToFile string) ([]float64, error) {
+	var d *onsetDetector
+	err := decode(ctx, pathToFile, func(s *pcmStream) error {
+		d = newOnsetDetector(s.sampleRate, s.channels)
+		return nil
+	}, func(samples []float64) {
+		d.Write(samples)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.Onsets(), nil
+}
+
+// decode passes all samples of the file to write, after calling start with
+// its audio stream.
+func decode(ctx context.Context, pathToFile string, start func(s *pcmStream) error, write func(samples []float64)) error {
+	format, err := nativeFormatOf(pathToFile)
+	if err != nil {
+		return err
+	}
+	s, f, err := openPCM(format, pathToFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := start(s); err != nil {
+		return fmt.Errorf("cannot decode %q: %w", pathToFile, err)
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		samples, err := s.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot decode %q: %w", pathToFile, err)
+		}
+		write(samples)
+	}
+}
+
+func nativeFormatOf(pathToFile string) (nativeFormat, error) {
+	ext := strings.ToLower(filepath.Ext(pathToFile))
+	format, ok := nativeFormats[ext]
+	if !ok {
+		return nativeFormat{}, fmt.Errorf("cannot decode %q files natively", ext)
+	}
+	return format, nil
+}
+
+// openPCM opens the file and its audio stream; the caller closes the file.
+func openPCM(format nativeFormat, pathToFile string) (*pcmStream, *os.File, error) {
+	f, err := os.Open(pathToFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := format.open(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("cannot decode %q: %w", pathToFile, err)
+	}
+	return s, f, nil
+}
+
+// sampleFormat - the encoding of uncompressed PCM samples
+type sampleFormat struct {
+	// size is the number of bytes per sample
+	size      int
+	float     bool
+	bigEndian bool
+	// unsigned marks 8-bit WAV samples, which are offset by 128
+	unsigned bool
+}
+
+// decoder returns a function converting one encoded sample.
+func (f sampleFormat) decoder() (func(b []byte) float64, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if f.bigEndian {
+		order = binary.BigEndian
+	}
+	switch {
+	case f.float && f.size == 4:
+		return func(b []byte) float64 { return float64(math.Float32frombits(order.Uint32(b))) }, nil
+	case f.float && f.size == 8:
+		return func(b []byte) float64 { return math.Float64frombits(order.Uint64(b)) }, nil
+	case f.float:
+		return nil, fmt.Errorf("unsupported %d-bit float samples", 8*f.size)
+	case f.size == 1 && f.unsigned:
+		return func(b []byte) float64 { return float64(int(b[0])-128) / 128 }, nil
+	case f.size >= 1 && f.size <= 4:
+		// samples of fewer bits than their container are left-justified, so
+		// scaling by the container size is exact
+		shift := 32 - 8*f.size
+		return func(b []byte) float64 {
+			var u uint32
+			for i := range f.size {
+				if f.bigEndian {
+					u = u<<8 | uint32(b[i])
+				} else {
+					u |= uint32(b[i]) << (8 * i)
+				}
+			}
+			return float64(int32(u<<shift)) / (1 << 31)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported %d-bit integer samples", 8*f.size)
+}
+
+// newPCMStream reads uncompressed samples of the given format from r.
+func newPCMStream(r io.Reader, sampleRate, channels int, format sampleFormat) (*pcmStream, error) {
+	if sampleRate <= 0 || channels <= 0 {
+		return nil, fmt.Errorf("invalid audio format: %d Hz, %d channels", sampleRate, channels)
+	}
+	decode, err := format.decoder()
+	if err != nil {
+		return nil, err
+	}
+	frameSize := format.size * channels
+	buf := make([]byte, pcmReadFrames*frameSize)
+	out := make([]float64, pcmReadFrames*channels)
+	return &pcmStream{
+		sampleRate: sampleRate,
+		channels:   channels,
+		next: func() ([]float64, error) {
+			n, err := io.ReadFull(r, buf)
+			// a truncated last sample frame is dropped
+			n -= n % frameSize
+			if n == 0 {
+				if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+					err = io.EOF
+				}
+				return nil, err
+			}
+			samples := out[:n/format.size]
+			for i := range samples {
+				samples[i] = decode(buf[i*format.size:])
+			}
+			return samples, nil
+		},
+	}, nil
+}
+
+// fallback - tries the native decoder first and ffmpeg if that fails, e.g.
+// on WAV files holding compressed audio or on damaged files
+type fallback struct {
+	native Native
+	ffmpeg FFmpeg
+}
+
+func (f fallback) Probe(ctx context.Context, pathToFile string) (map[string]string, error) {
+	tags, err := f.native.Probe(ctx, pathToFile)
+	if err == nil || ctx.Err() != nil {
+		return tags, err
+	}
+	tags, ffErr := f.ffmpeg.Probe(ctx, pathToFile)
+	if ffErr != nil {
+		return nil, errors.Join(err, ffErr)
+	}
+	return tags, nil
+}
+
+func (f fallback) Measure(ctx context.Context, pathToFile string) (*Measurement, error) {
+	m, err := f.native.Measure(ctx, pathToFile)
+	if err == nil || ctx.Err() != nil {
+		return m, err
+	}
+	m, ffErr := f.ffmpeg.Measure(ctx, pathToFile)
+	if ffErr != nil {
+		return nil, errors.Join(err, ffErr)
+	}
+	return m, nil
+}
+
+func (f fallback) Onsets(ctx context.Context, pathToFile string) ([]float64, error) {
+	o, err := f.native.Onsets(ctx, pathToFile)
+	if err == nil || ctx.Err() != nil {
+		return o, err
+	}
+	o, ffErr := f.ffmpeg.Onsets(ctx, pathToFile)
+	if ffErr != nil {
+		return nil, errors.Join(err, ffErr)
+	}
+	return o, nil
+}
+
+// hasNativeDecoder reports whether Native decodes files with this extension.
+func hasNativeDecoder(pathToFile string) bool {
+	_, ok := nativeFormats[strings.ToLower(filepath.Ext(pathToFile))]
+	return ok
+}
@@ -0,0 +1,664 @@
+package cue
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type NativeSuite struct {
+	suite.Suite
+}
+
+func TestNativeSuite(t *testing.T) {
+	suite.Run(t, &NativeSuite{})
+}
+
+// encodeSamples encodes interleaved samples in the given format.
+func encodeSamples(format sampleFormat, samples []float64) []byte {
+	var order binary.AppendByteOrder = binary.LittleEndian
+	if format.bigEndian {
+		order = binary.BigEndian
+	}
+	var out []byte
+	for _, x := range samples {
+		switch {
+		case format.float && format.size == 4:
+			out = order.AppendUint32(out, math.Float32bits(float32(x)))
+		case format.float:
+			out = order.AppendUint64(out, math.Float64bits(x))
+		case format.unsigned:
+			out = append(out, byte(int(math.Round(x*127))+128))
+		default:
+			v := uint32(int32(math.Round(x*float64(int64(1)<<(8*format.size-1)-1)))) << (32 - 8*format.size)
+			b := order.AppendUint32(nil, v)
+			if format.bigEndian {
+				out = append(out, b[:format.size]...)
+			} else {
+				out = append(out, b[4-format.size:]...)
+			}
+		}
+	}
+	return out
+}
+
+// writePCMWAV writes samples as a WAV file, with a WAVE_FORMAT_EXTENSIBLE fmt
+// chunk if extensible is set.
+func writePCMWAV(path string, sampleRate, channels int, format sampleFormat, extensible bool, samples []float64) error {
+	tag := uint16(wavePCM)
+	if format.float {
+		tag = waveFloat
+	}
+	fmtChunk := binary.LittleEndian.AppendUint16(nil, tag)
+	if extensible {
+		fmtChunk = binary.LittleEndian.AppendUint16(nil, waveExtensible)
+	}
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(channels))
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, uint32(sampleRate))
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, uint32(sampleRate*channels*format.size))
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(channels*format.size))
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(8*format.size))
+	if extensible {
+		fmtChunk = append(fmtChunk, 22, 0)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(8*format.size))
+		fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, 3)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, tag)
+		fmtChunk = append(fmtChunk, "\x00\x00\x00\x00\x10\x00\x80\x00\x00\xaa\x00\x38\x9b\x71"...)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("RIFF\x00\x00\x00\x00WAVE")
+	for _, ch := range []struct {
+		id   string
+		data []byte
+	}{
+		{"fmt ", fmtChunk},
+		{"JUNK", []byte{1, 2, 3}},
+		{"data", encodeSamples(format, samples)},
+	} {
+		b.WriteString(ch.id)
+		_ = binary.Write(&b, binary.LittleEndian, uint32(len(ch.data)))
+		b.Write(ch.data)
+		if len(ch.data)%2 == 1 {
+			b.WriteByte(0)
+		}
+	}
+	out := b.Bytes()
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return os.WriteFile(path, out, 0o644)
+}
+
+// writeAIFF writes samples as an AIFF file, or as an AIFC file with the
+// given compression type; tags go into an "ID3 " chunk.
+func writeAIFF(path string, sampleRate, channels, bitDepth int, compression string, samples []float64, tags map[string]string) error {
+	format := sampleFormat{size: (bitDepth + 7) / 8, bigEndian: true}
+	if compression != "" {
+		format = aiffSampleFormats[compression]
+		if !format.float {
+			format.size = (bitDepth + 7) / 8
+		}
+	}
+	comm := binary.BigEndian.AppendUint16(nil, uint16(channels))
+	comm = binary.BigEndian.AppendUint32(comm, uint32(len(samples)/channels))
+	comm = binary.BigEndian.AppendUint16(comm, uint16(bitDepth))
+	exp := bits.Len64(uint64(sampleRate)) - 1
+	comm = binary.BigEndian.AppendUint16(comm, uint16(16383+exp))
+	comm = binary.BigEndian.AppendUint64(comm, uint64(sampleRate)<<(63-exp))
+	form := "AIFF"
+	if compression != "" {
+		form = "AIFC"
+		comm = append(comm, compression+"\x00\x00"...)
+	}
+	// an SSND offset of 4 skips alignment bytes
+	ssnd := append([]byte{0, 0, 0, 4, 0, 0, 0, 0, 0xaa, 0xaa, 0xaa, 0xaa}, encodeSamples(format, samples)...)
+
+	var b bytes.Buffer
+	b.WriteString("FORM\x00\x00\x00\x00" + form)
+	chunks := []struct {
+		id   string
+		data []byte
+	}{{"COMM", comm}, {"SSND", ssnd}}
+	if tags != nil {
+		t := &id3Tag{version: 4}
+		t.set(tags)
+		chunks = append(chunks, struct {
+			id   string
+			data []byte
+		}{"ID3 ", t.encode(0)})
+	}
+	for _, ch := range chunks {
+		b.WriteString(ch.id)
+		_ = binary.Write(&b, binary.BigEndian, uint32(len(ch.data)))
+		b.Write(ch.data)
+		if len(ch.data)%2 == 1 {
+			b.WriteByte(0)
+		}
+	}
+	out := b.Bytes()
+	binary.BigEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return os.WriteFile(path, out, 0o644)
+}
+
+// bitWriter - writes big-endian bit fields, the inverse of bitReader
+type bitWriter struct {
+	buf []byte
+	cur byte
+	n   uint
+}
+
+func (w *bitWriter) write(v uint64, n uint) {
+	for i := n; i > 0; i-- {
+		w.cur = w.cur<<1 | byte(v>>(i-1)&1)
+		if w.n++; w.n == 8 {
+			w.buf = append(w.buf, w.cur)
+			w.cur, w.n = 0, 0
+		}
+	}
+}
+
+func (w *bitWriter) signed(v int64, n uint) {
+	w.write(uint64(v)&(1<<n-1), n)
+}
+
+func (w *bitWriter) align() {
+	for w.n != 0 {
+		w.write(0, 1)
+	}
+}
+
+// encodeFLAC encodes per-channel samples as FLAC, cycling through the
+// subframe types, channel assignments and residual codings so that a round
+// trip exercises all of the decoder. CRCs are left zero.
+func encodeFLAC(sampleRate, bps int, channels [][]int64) []byte {
+	const blockSize = 4096
+	total := len(channels[0])
+	w := &bitWriter{buf: []byte("fLaC")}
+	// STREAMINFO, followed by a last padding block
+	w.write(0, 1)
+	w.write(flacStreamInfo, 7)
+	w.write(34, 24)
+	w.write(blockSize, 16)
+	w.write(blockSize, 16)
+	w.write(0, 48)
+	w.write(uint64(sampleRate), 20)
+	w.write(uint64(len(channels)-1), 3)
+	w.write(uint64(bps-1), 5)
+	w.write(uint64(total), 36)
+	w.write(0, 128)
+	w.write(1, 1)
+	w.write(flacPadding, 7)
+	w.write(8, 24)
+	w.write(0, 64)
+
+	for frame, start := 0, 0; start < total; frame, start = frame+1, start+blockSize {
+		n := min(blockSize, total-start)
+		w.write(0xfff8, 16)
+		if n == blockSize {
+			w.write(12, 4) // 256 << 4
+		} else {
+			w.write(7, 4)
+		}
+		rateCode := uint64(0)
+		if frame%2 == 1 {
+			rateCode = 13
+		}
+		w.write(rateCode, 4)
+		assignment := len(channels) - 1
+		if len(channels) == 2 {
+			assignment = []int{1, flacLeftSide, flacSideRight, flacMidSide}[frame%4]
+		}
+		w.write(uint64(assignment), 4)
+		w.write(0, 4) // sample size from STREAMINFO
+		for _, b := range utf8.AppendRune(nil, rune(frame*100)) {
+			w.write(uint64(b), 8)
+		}
+		if n != blockSize {
+			w.write(uint64(n-1), 16)
+		}
+		if rateCode == 13 {
+			w.write(uint64(sampleRate), 16)
+		}
+		w.write(0, 8)
+
+		subframes := make([][]int64, len(channels))
+		for ch := range channels {
+			subframes[ch] = channels[ch][start : start+n]
+		}
+		sideBPS := -1
+		if len(channels) == 2 {
+			left, right := subframes[0], subframes[1]
+			side, mid := make([]int64, n), make([]int64, n)
+			for i := range n {
+				side[i] = left[i] - right[i]
+				mid[i] = (left[i] + right[i]) >> 1
+			}
+			switch assignment {
+			case flacLeftSide:
+				subframes[1], sideBPS = side, 1
+			case flacSideRight:
+				subframes[0], sideBPS = side, 0
+			case flacMidSide:
+				subframes[0], subframes[1], sideBPS = mid, side, 1
+			}
+		}
+		for ch, s := range subframes {
+			chBPS := uint(bps)
+			if ch == sideBPS {
+				chBPS++
+			}
+			encodeSubframe(w, s, chBPS, frame+ch)
+		}
+		w.align()
+		w.write(0, 16)
+	}
+	return w.buf
+}
+
+// encodeSubframe writes s as one of the subframe types, chosen by variant.
+func encodeSubframe(w *bitWriter, s []int64, bps uint, variant int) {
+	var or int64
+	constant := true
+	for _, v := range s {
+		or |= v
+		constant = constant && v == s[0]
+	}
+	w.write(0, 1)
+	if constant {
+		w.write(0, 6)
+		w.write(0, 1)
+		w.signed(s[0], bps)
+		return
+	}
+	wasted := uint(bits.TrailingZeros64(uint64(or)))
+	if wasted > 0 {
+		s = append([]int64(nil), s...)
+		for i := range s {
+			s[i] >>= wasted
+		}
+	}
+
+	var (
+		typ    uint64
+		order  int
+		coeffs []int64
+		shift  = 10
+		pred   func(i int) int64
+	)
+	switch variant % 4 {
+	case 0:
+		typ = 1
+	case 1:
+		order = 2
+		typ = 8 + uint64(order)
+		pred = func(i int) int64 { return 2*s[i-1] - s[i-2] }
+	case 2:
+		order = 4
+		typ = 8 + uint64(order)
+		pred = func(i int) int64 { return 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4] }
+	case 3:
+		coeffs = []int64{1800, -900, 120}
+		order = len(coeffs)
+		typ = 31 + uint64(order)
+		pred = func(i int) int64 {
+			var sum int64
+			for j, c := range coeffs {
+				sum += c * s[i-1-j]
+			}
+			return sum >> shift
+		}
+	}
+	w.write(typ, 6)
+	if wasted > 0 {
+		w.write(1, 1)
+		w.write(0, wasted-1)
+		w.write(1, 1)
+		bps -= wasted
+	} else {
+		w.write(0, 1)
+	}
+	if typ == 1 {
+		for _, v := range s {
+			w.signed(v, bps)
+		}
+		return
+	}
+	for _, v := range s[:order] {
+		w.signed(v, bps)
+	}
+	if coeffs != nil {
+		w.write(11, 4) // 12 bit precision
+		w.signed(int64(shift), 5)
+		for _, c := range coeffs {
+			w.signed(c, 12)
+		}
+	}
+
+	residual := make([]int64, len(s))
+	for i := order; i < len(s); i++ {
+		residual[i] = s[i] - pred(i)
+	}
+	method := uint64(variant / 4 % 2)
+	paramBits := uint(4 + method)
+	partitionOrder := 0
+	if len(s)%4 == 0 {
+		partitionOrder = 2
+	}
+	w.write(method, 2)
+	w.write(uint64(partitionOrder), 4)
+	partitionLen := len(s) >> partitionOrder
+	for p := range 1 << partitionOrder {
+		part := residual[max(order, p*partitionLen) : (p+1)*partitionLen]
+		if p == 1 {
+			// an escaped partition of raw samples
+			w.write(1<<paramBits-1, paramBits)
+			w.write(uint64(bps+6), 5)
+			for _, v := range part {
+				w.signed(v, bps+6)
+			}
+			continue
+		}
+		var sum uint64
+		for _, v := range part {
+			sum += uint64(v<<1 ^ v>>63)
+		}
+		k := uint(bits.Len64(sum / uint64(max(1, len(part)))))
+		k = min(k, 1<<paramBits-2)
+		w.write(uint64(k), paramBits)
+		for _, v := range part {
+			z := uint64(v<<1 ^ v>>63)
+			for range z >> k {
+				w.write(0, 1)
+			}
+			w.write(1, 1)
+			w.write(z&(1<<k-1), k)
+		}
+	}
+}
+
+// testSignal returns per-channel integer samples: a sine with a silent
+// section and a section whose samples share trailing zero bits.
+func testSignal(sampleRate, channels, bps, frames int) [][]int64 {
+	peak := float64(int64(1)<<(bps-1) - 1)
+	out := make([][]int64, channels)
+	for ch := range out {
+		out[ch] = make([]int64, frames)
+		for i := range out[ch] {
+			x := 0.8 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate)+float64(ch))
+			v := int64(math.Round(x * peak))
+			switch {
+			case i >= frames/3 && i < frames/2:
+				v = 0
+			case i >= frames/2 && i < 2*frames/3:
+				v &^= 7
+			}
+			out[ch][i] = v
+		}
+	}
+	return out
+}
+
+// readAll decodes the whole stream.
+func (s *NativeSuite) readAll(stream *pcmStream) []float64 {
+	var out []float64
+	for {
+		samples, err := stream.next()
+		if err == io.EOF {
+			return out
+		}
+		s.Require().NoError(err)
+		out = append(out, samples...)
+	}
+}
+
+func (s *NativeSuite) open(path string) *pcmStream {
+	format, err := nativeFormatOf(path)
+	s.Require().NoError(err)
+	stream, f, err := openPCM(format, path)
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = f.Close() })
+	return stream
+}
+
+func (s *NativeSuite) TestWAV() {
+	dir := s.T().TempDir()
+	samples := tones(44100, 2, tone{freq: 440, dbfs: -3, seconds: 0.5})
+	for _, tc := range []struct {
+		name       string
+		format     sampleFormat
+		extensible bool
+	}{
+		{"u8", sampleFormat{size: 1, unsigned: true}, false},
+		{"s16", sampleFormat{size: 2}, false},
+		{"s24", sampleFormat{size: 3}, false},
+		{"s32", sampleFormat{size: 4}, false},
+		{"f32", sampleFormat{size: 4, float: true}, false},
+		{"f64", sampleFormat{size: 8, float: true}, false},
+		{"s24 extensible", sampleFormat{size: 3}, true},
+		{"f32 extensible", sampleFormat{size: 4, float: true}, true},
+	} {
+		s.Run(tc.name, func() {
+			path := filepath.Join(dir, tc.name+".wav")
+			s.Require().NoError(writePCMWAV(path, 44100, 2, tc.format, tc.extensible, samples))
+			stream := s.open(path)
+			s.Equal(44100, stream.sampleRate)
+			s.Equal(2, stream.channels)
+			s.Equal(int64(len(samples)/2), stream.frames)
+			got := s.readAll(stream)
+			s.Require().Len(got, len(samples))
+			// two quantization steps, or float32 precision
+			step := 1e-6
+			if !tc.format.float {
+				step = 4 / math.Pow(2, float64(8*tc.format.size))
+			}
+			for i := range samples {
+				s.Require().InDelta(samples[i], got[i], step, "sample %d", i)
+			}
+		})
+	}
+
+	// compressed audio is left to ffmpeg
+	path := filepath.Join(dir, "mp3.wav")
+	s.Require().NoError(writePCMWAV(path, 44100, 2, sampleFormat{size: 2}, false, samples))
+	b, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	binary.LittleEndian.PutUint16(b[20:22], 0x0055)
+	s.Require().NoError(os.WriteFile(path, b, 0o644))
+	_, err = Native{}.Measure(s.T().Context(), path)
+	s.ErrorContains(err, "unsupported WAV encoding 0x0055")
+}
+
+func (s *NativeSuite) TestAIFF() {
+	dir := s.T().TempDir()
+	samples := tones(44100, 2, tone{freq: 440, dbfs: -3, seconds: 0.5})
+	for _, tc := range []struct {
+		name        string
+		bits        int
+		compression string
+	}{
+		{"s16.aiff", 16, ""},
+		{"s24.aif", 24, ""},
+		{"s8.aiff", 8, ""},
+		{"sowt.aifc", 16, "sowt"},
+		{"fl32.aifc", 32, "fl32"},
+		{"fl64.aifc", 64, "fl64"},
+	} {
+		s.Run(tc.name, func() {
+			path := filepath.Join(dir, tc.name)
+			s.Require().NoError(writeAIFF(path, 44100, 2, tc.bits, tc.compression, samples, nil))
+			stream := s.open(path)
+			s.Equal(44100, stream.sampleRate)
+			s.Equal(2, stream.channels)
+			s.Equal(int64(len(samples)/2), stream.frames)
+			got := s.readAll(stream)
+			s.Require().Len(got, len(samples))
+			step := max(1e-6, 4/math.Pow(2, float64(tc.bits)))
+			for i := range samples {
+				s.Require().InDelta(samples[i], got[i], step, "sample %d", i)
+			}
+		})
+	}
+
+	path := filepath.Join(dir, "ima4.aifc")
+	s.Require().NoError(writeAIFF(path, 44100, 2, 16, "ima4", samples, nil))
+	_, err := Native{}.Measure(s.T().Context(), path)
+	s.ErrorContains(err, `unsupported AIFC compression "ima4"`)
+}
+
+func (s *NativeSuite) TestFLAC() {
+	dir := s.T().TempDir()
+	for _, tc := range []struct {
+		name     string
+		bps      int
+		channels int
+		frames   int
+	}{
+		{"stereo16", 16, 2, 3*4096 + 1000},
+		{"stereo24", 24, 2, 4*4096 + 7},
+		{"mono16", 16, 1, 2*4096 + 5},
+		{"surround24", 24, 6, 4096},
+	} {
+		s.Run(tc.name, func() {
+			want := testSignal(48000, tc.channels, tc.bps, tc.frames)
+			path := filepath.Join(dir, tc.name+".flac")
+			s.Require().NoError(os.WriteFile(path, encodeFLAC(48000, tc.bps, want), 0o644))
+
+			stream := s.open(path)
+			s.Equal(48000, stream.sampleRate)
+			s.Equal(tc.channels, stream.channels)
+			s.Equal(int64(tc.frames), stream.frames)
+			got := s.readAll(stream)
+			s.Require().Len(got, tc.frames*tc.channels)
+			scale := float64(int64(1) << (tc.bps - 1))
+			for i, x := range got {
+				s.Require().Equal(want[i%tc.channels][i/tc.channels], int64(x*scale), "sample %d", i)
+			}
+		})
+	}
+
+	path := filepath.Join(dir, "broken.flac")
+	b := encodeFLAC(48000, 16, testSignal(48000, 2, 16, 10000))
+	s.Require().NoError(os.WriteFile(path, b[:len(b)/2], 0o644))
+	_, err := Native{}.Measure(s.T().Context(), path)
+	s.ErrorIs(err, io.ErrUnexpectedEOF)
+}
+
+// TestMeasure checks that Native measures exactly what a Meter fed with the
+// samples does, and reads the tags and duration from the headers.
+func (s *NativeSuite) TestMeasure() {
+	dir := s.T().TempDir()
+	samples := tones(48000, 2,
+		tone{freq: 440, dbfs: -30, seconds: 1},
+		tone{freq: 1000, dbfs: -12, seconds: 3},
+	)
+	for i := range samples {
+		samples[i] = float64(float32(samples[i]))
+	}
+	m, err := NewMeter(48000, 2)
+	s.Require().NoError(err)
+	m.Write(samples)
+	want := m.Measurement()
+	d := newOnsetDetector(48000, 2)
+	d.Write(samples)
+	want.Onsets = d.Onsets()
+
+	path := filepath.Join(dir, "a.wav")
+	s.Require().NoError(writeFloatWAV(path, 48000, 2, samples))
+	got, err := Native{DetectOnsets: true}.Measure(s.T().Context(), path)
+	s.Require().NoError(err)
+	s.Equal(want, got)
+
+	path = filepath.Join(dir, "a.aiff")
+	s.Require().NoError(writeAIFF(path, 48000, 2, 32, "fl32", samples, map[string]string{"LIQ_CUE_IN": "0.50"}))
+	got, err = Native{DetectOnsets: true}.Measure(s.T().Context(), path)
+	s.Require().NoError(err)
+	s.Equal(want, got)
+	// onsets are only detected on request
+	got, err = Native{}.Measure(s.T().Context(), path)
+	s.Require().NoError(err)
+	s.Nil(got.Onsets)
+	s.Equal(want.Frames, got.Frames)
+	tags, err := Native{}.Probe(s.T().Context(), path)
+	s.Require().NoError(err)
+	s.Equal(map[string]string{"duration": "4.000000", "liq_cue_in": "0.50"}, tags)
+
+	ctx, cancel := context.WithCancel(s.T().Context())
+	cancel()
+	_, err = Native{}.Measure(ctx, path)
+	s.ErrorIs(err, context.Canceled)
+
+	_, err = Native{}.Probe(s.T().Context(), "a.mp3")
+	s.ErrorContains(err, "cannot decode")
+}
+
+// TestCalc checks that WAV, AIFF and FLAC files are analysed without ffmpeg,
+// which is only used for formats the native decoders cannot handle.
+func (s *NativeSuite) TestCalc() {
+	dir := s.T().TempDir()
+	samples := tones(44100, 2,
+		tone{freq: 440, dbfs: -80, seconds: 1},
+		tone{freq: 440, dbfs: -14, seconds: 8},
+		tone{freq: 440, dbfs: -80, seconds: 1},
+	)
+	wav := filepath.Join(dir, "a.wav")
+	s.Require().NoError(writePCMWAV(wav, 44100, 2, sampleFormat{size: 2}, false, samples))
+	flac := filepath.Join(dir, "a.flac")
+	ints := make([][]int64, 2)
+	for i, x := range samples {
+		ints[i%2] = append(ints[i%2], int64(math.Round(x*math.MaxInt16)))
+	}
+	s.Require().NoError(os.WriteFile(flac, encodeFLAC(44100, 16, ints), 0o644))
+
+	opts := DefaultCalculatorOptions()
+	opts.FFmpeg = FFmpeg{FFmpegPath: filepath.Join(dir, "missing"), FFprobePath: filepath.Join(dir, "missing")}
+	c := NewCalculator(&opts)
+	for _, path := range []string{wav, flac} {
+		res, err := c.Calc(path)
+		s.Require().NoError(err)
+		s.InDelta(1.0, res.CueIn, 0.15)
+		// the momentary loudness lags behind by up to 400 ms
+		s.InDelta(9.2, res.CueOut, 0.2)
+		s.InDelta(10.0, res.Duration, 1e-9)
+	}
+
+	opts.FFmpegOnly = true
+	_, err := NewCalculator(&opts).Calc(wav)
+	s.ErrorContains(err, "missing")
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// compressed WAV files fall back to ffmpeg
+	b, err := os.ReadFile(wav)
+	s.Require().NoError(err)
+	binary.LittleEndian.PutUint16(b[20:22], 0x0055)
+	s.Require().NoError(os.WriteFile(wav, b, 0o644))
+	script, _ := argsScript(s.T(), `{"streams":[{"codec_type":"audio","duration":"10.0"}],"format":{}}`)
+	opts = DefaultCalculatorOptions()
+	opts.FFmpeg = FFmpeg{FFprobePath: script, FFmpegPath: filepath.Join(dir, "missing")}
+	_, err = NewCalculator(&opts).Calc(wav)
+	s.ErrorContains(err, "unsupported WAV encoding")
+	s.ErrorContains(err, "missing")
+}
+
+func BenchmarkNativeFLAC(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "a.flac")
+	if err := os.WriteFile(path, encodeFLAC(44100, 16, testSignal(44100, 2, 16, 10*44100)), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for b.Loop() {
+		if _, err := (Native{}).Measure(b.Context(), path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package cue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	oggCapturePattern = []byte("OggS")
+	// identification and comment header magics of the supported codecs
+	vorbisIDMagic      = []byte("\x01vorbis")
+	vorbisCommentMagic = []byte("\x03vorbis")
+	opusIDMagic        = []byte("OpusHead")
+	opusCommentMagic   = []byte("OpusTags")
+)
+
+const (
+	oggHeaderSize   = 27
+	oggContinuation = 0x01
+	oggBOS          = 0x02
+	// granule position of pages on which no packet ends
+	oggNoGranule = ^uint64(0)
+)
+
+// oggCRCTable - lookup table for the Ogg CRC-32 (polynomial 0x04c11db7, no
+// reflection, zero initial value)
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// oggPage - a single Ogg page
+type oggPage struct {
+	headerType byte
+	granule    uint64
+	serial     uint32
+	seq        uint32
+	lacing     []byte
+	body       []byte
+}
+
+// readOggPage reads the next page from r.
+func readOggPage(r io.Reader) (*oggPage, error) {
+	var hdr [oggHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hdr[0:4], oggCapturePattern) || hdr[4] != 0 {
+		return nil, errors.New("invalid Ogg page")
+	}
+	p := &oggPage{
+		headerType: hdr[5],
+		granule:    binary.LittleEndian.Uint64(hdr[6:14]),
+		serial:     binary.LittleEndian.Uint32(hdr[14:18]),
+		seq:        binary.LittleEndian.Uint32(hdr[18:22]),
+		lacing:     make([]byte, hdr[26]),
+	}
+	if _, err := io.ReadFull(r, p.lacing); err != nil {
+		return nil, err
+	}
+	size := 0
+	for _, l := range p.lacing {
+		size += int(l)
+	}
+	p.body = make([]byte, size)
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// encode serialises the page, computing its checksum.
+func (p *oggPage) encode() []byte {
+	b := make([]byte, oggHeaderSize, oggHeaderSize+len(p.lacing)+len(p.body))
+	copy(b, oggCapturePattern)
+	b[5] = p.headerType
+	binary.LittleEndian.PutUint64(b[6:14], p.granule)
+	binary.LittleEndian.PutUint32(b[14:18], p.serial)
+	binary.LittleEndian.PutUint32(b[18:22], p.seq)
+	b[26] = byte(len(p.lacing))
+	b = append(append(b, p.lacing...), p.body...)
+
+	var crc uint32
+	for _, c := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^c]
+	}
+	binary.LittleEndian.PutUint32(b[22:26], crc)
+	return b
+}
+
+// size returns the encoded size of the page.
+func (p *oggPage) size() int64 {
+	return int64(oggHeaderSize + len(p.lacing) + len(p.body))
+}
+
+// oggHeaders - the header packets of the first logical stream of an Ogg file
+type oggHeaders struct {
+	opus bool
+	// pages holding the packets after the identification header, which has a
+	// page of its own; they end on a packet boundary
+	pages []*oggPage
+	// the comment packet followed by the Vorbis setup packet (Vorbis only)
+	packets [][]byte
+	// offsets of the first page after the identification page and of the
+	// first page after the headers
+	start, end int64
+}
+
+// readOggHeaders reads the identification page and the comment (and setup)
+// header pages of a single Vorbis or Opus stream.
+func readOggHeaders(r io.Reader) (*oggHeaders, error) {
+	first, err := readOggPage(r)
+	if err != nil {
+		return nil, err
+	}
+	h := &oggHeaders{start: first.size(), end: first.size()}
+	wantPackets := 0
+	switch {
+	case first.headerType&oggBOS == 0:
+		return nil, errors.New("missing Ogg beginning of stream page")
+	case bytes.HasPrefix(first.body, vorbisIDMagic):
+		wantPackets = 2 // comment and setup headers
+	case bytes.HasPrefix(first.body, opusIDMagic):
+		h.opus = true
+		wantPackets = 1 // comment header
+	default:
+		return nil, errors.New("unsupported Ogg codec, expected Vorbis or Opus")
+	}
+
+	var packet []byte
+	for len(h.packets) < wantPackets {
+		p, err := readOggPage(r)
+		if err != nil {
+			return nil, err
+		}
+		if p.serial != first.serial {
+			return nil, errors.New("multiplexed Ogg streams are not supported")
+		}
+		h.pages = append(h.pages, p)
+		h.end += p.size()
+		body := p.body
+		for _, l := range p.lacing {
+			packet = append(packet, body[:l]...)
+			body = body[l:]
+			if l < 255 {
+				h.packets = append(h.packets, packet)
+				packet = nil
+			}
+		}
+	}
+	if len(h.packets) != wantPackets || packet != nil {
+		return nil, errors.New("Ogg header packets do not end on a page boundary")
+	}
+
+	magic := vorbisCommentMagic
+	if h.opus {
+		magic = opusCommentMagic
+	}
+	if !bytes.HasPrefix(h.packets[0], magic) {
+		return nil, errors.New("missing Ogg comment header")
+	}
+	return h, nil
+}
+
+// comment decodes the Vorbis comment block of the comment packet.
+func (h *oggHeaders) comment() (*vorbisComment, error) {
+	if h.opus {
+		return parseVorbisComment(h.packets[0][len(opusCommentMagic):])
+	}
+	return parseVorbisComment(h.packets[0][len(vorbisCommentMagic):])
+}
+
+// commentPacket builds a comment packet holding vc, padded to at least size
+// bytes if possible. Trailing Opus data that must be preserved (lsb of its
+// first byte set) is kept as is.
+func (h *oggHeaders) commentPacket(vc *vorbisComment, size int) []byte {
+	old := h.packets[0]
+	block := vc.encode()
+	if h.opus {
+		packet := append(append([]byte{}, opusCommentMagic...), block...)
+		if oldVC, err := h.comment(); err == nil {
+			if extra := old[len(opusCommentMagic)+len(oldVC.encode()):]; len(extra) > 0 && extra[0]&1 == 1 {
+				return append(packet, extra...)
+			}
+		}
+		return append(packet, make([]byte, max(size-len(packet), 0))...)
+	}
+	packet := append(append([]byte{}, vorbisCommentMagic...), block...)
+	packet = append(packet, 1) // framing bit
+	return append(packet, make([]byte, max(size-len(packet), 0))...)
+}
+
+// readOggTags returns the comment header fields of an Ogg Vorbis or Opus file.
+func readOggTags(pathToFile string) (map[string]string, error) {
+	f, err := os.Open(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	h, err := readOggHeaders(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+	vc, err := h.comment()
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+	return vc.tags(), nil
+}
+
+// writeOggTags sets tags in the comment header of an Ogg Vorbis or Opus file.
+// If the new comment packet is not larger than the old one, it is padded to the
+// same size and the header pages are rewritten in place; otherwise the file is
+// rewritten with a padded comment packet, so the next update fits.
+func writeOggTags(pathToFile string, tags map[string]string) error {
+	f, err := os.OpenFile(pathToFile, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	h, err := readOggHeaders(f)
+	if err != nil {
+		return fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+	vc, err := h.comment()
+	if err != nil {
+		return fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+	vc.set(tags)
+
+	packet := h.commentPacket(vc, len(h.packets[0]))
+	if len(packet) == len(h.packets[0]) {
+		// same packet sizes: same lacing, only the page bodies change
+		data := append(packet, bytes.Join(h.packets[1:], nil)...)
+		var buf bytes.Buffer
+		for _, p := range h.pages {
+			p.body, data = data[:len(p.body)], data[len(p.body):]
+			buf.Write(p.encode())
+		}
+		_, err := f.WriteAt(buf.Bytes(), h.start)
+		return err
+	}
+
+	packet = h.commentPacket(vc, len(packet)+tagPadding)
+	pages := paginateOgg(append([][]byte{packet}, h.packets[1:]...), h.pages[0].serial, h.pages[0].seq)
+	return rewriteOgg(pathToFile, f, h, pages)
+}
+
+// paginateOgg lays out header packets on pages of at most 255 lacing values,
+// numbered from seq on.
+func paginateOgg(packets [][]byte, serial, seq uint32) []*oggPage {
+	var (
+		pages []*oggPage
+		cur   *oggPage
+	)
+	newPage := func(continued bool) {
+		cur = &oggPage{serial: serial, seq: seq, granule: oggNoGranule}
+		if continued {
+			cur.headerType = oggContinuation
+		}
+		seq++
+		pages = append(pages, cur)
+	}
+	newPage(false)
+	for _, packet := range packets {
+		rest := packet
+		for continued := false; ; continued = true {
+			if len(cur.lacing) == 255 {
+				newPage(continued)
+			}
+			l := min(len(rest), 255)
+			cur.lacing = append(cur.lacing, byte(l))
+			cur.body = append(cur.body, rest[:l]...)
+			rest = rest[l:]
+			if l < 255 {
+				cur.granule = 0 // header packets have a zero granule position
+				break
+			}
+		}
+	}
+	return pages
+}
+
+// rewriteOgg copies src into a temporary sibling file with the new header pages
+// and replaces the original. Later pages of the stream are renumbered if the
+// header page count changed.
+func rewriteOgg(pathToFile string, src io.ReadSeeker, h *oggHeaders, pages []*oggPage) error {
+	tmp, err := siblingTempFile(pathToFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	err = copyOggPages(dst, src, h, pages)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return replaceFile(pathToFile, tmp)
+}
+
+// copyOggPages writes the identification page, the new header pages and the
+// remaining pages of src to dst.
+func copyOggPages(dst io.Writer, src io.ReadSeeker, h *oggHeaders, pages []*oggPage) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(dst, src, h.start); err != nil {
+		return err
+	}
+	for _, p := range pages {
+		if _, err := dst.Write(p.encode()); err != nil {
+			return err
+		}
+	}
+	if _, err := src.Seek(h.end, io.SeekStart); err != nil {
+		return err
+	}
+
+	shift := uint32(len(pages) - len(h.pages))
+	if shift == 0 {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	serial := h.pages[0].serial
+	for {
+		p, err := readOggPage(src)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if p.serial == serial {
+			p.seq += shift
+		}
+		if _, err := dst.Write(p.encode()); err != nil {
+			return err
+		}
+	}
+}
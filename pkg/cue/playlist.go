@@ -0,0 +1,175 @@
+package cue
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PlaylistEntry - a single playlist item
+type PlaylistEntry struct {
+	// Path is the file path (resolved against the playlist's directory) or URL
+	Path string
+	// Annotations holds the metadata of a Liquidsoap "annotate:" entry, if any
+	Annotations map[string]string
+}
+
+// ReadPlaylist - reads an M3U/M3U8, PLS or XSPF playlist, chosen by its file
+// extension. Relative paths and file:// URIs are resolved to local paths;
+// other URLs are kept as they are.
+func ReadPlaylist(path string) ([]PlaylistEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var locations []string
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".m3u", ".m3u8":
+		locations, err = readM3U(f)
+	case ".pls":
+		locations, err = readPLS(f)
+	case ".xspf":
+		locations, err = readXSPF(f)
+	default:
+		return nil, fmt.Errorf("unsupported playlist type %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read playlist %q: %w", path, err)
+	}
+
+	base := filepath.Dir(path)
+	entries := make([]PlaylistEntry, 0, len(locations))
+	for _, loc := range locations {
+		var entry PlaylistEntry
+		if meta, uri, ok := parseAnnotate(loc); ok {
+			entry.Annotations, loc = meta, uri
+		}
+		entry.Path = resolveLocation(base, loc)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// readM3U returns the non-comment lines of an (extended) M3U playlist.
+func readM3U(r io.Reader) ([]string, error) {
+	var locations []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		locations = append(locations, line)
+	}
+	return locations, scanner.Err()
+}
+
+// readPLS returns the FileN entries of a PLS playlist, ordered by N.
+func readPLS(r io.Reader) ([]string, error) {
+	type numbered struct {
+		n   int
+		loc string
+	}
+	var files []numbered
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, val, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || len(key) <= 4 || !strings.EqualFold(key[:4], "file") {
+			continue
+		}
+		n, err := strconv.Atoi(key[4:])
+		if err != nil {
+			continue
+		}
+		files = append(files, numbered{n: n, loc: strings.TrimSpace(val)})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].n < files[j].n })
+	locations := make([]string, len(files))
+	for i, f := range files {
+		locations[i] = f.loc
+	}
+	return locations, scanner.Err()
+}
+
+// readXSPF returns the track locations of an XSPF playlist.
+func readXSPF(r io.Reader) ([]string, error) {
+	var playlist struct {
+		Tracks []struct {
+			Locations []string `xml:"location"`
+		} `xml:"trackList>track"`
+	}
+	if err := xml.NewDecoder(r).Decode(&playlist); err != nil {
+		return nil, err
+	}
+	var locations []string
+	for _, t := range playlist.Tracks {
+		// a track may list alternative locations; the first one is used
+		if len(t.Locations) > 0 {
+			locations = append(locations, strings.TrimSpace(t.Locations[0]))
+		}
+	}
+	return locations, nil
+}
+
+// resolveLocation turns a playlist location into a local path where possible.
+func resolveLocation(base, loc string) string {
+	if u, err := url.Parse(loc); err == nil && len(u.Scheme) > 1 {
+		if u.Scheme != "file" {
+			return loc
+		}
+		loc = filepath.FromSlash(u.Path)
+	}
+	if !filepath.IsAbs(loc) {
+		loc = filepath.Join(base, loc)
+	}
+	return loc
+}
+
+// WriteAnnotatedM3U - writes an extended M3U playlist with one Liquidsoap
+// "annotate:" URI per entry that has a result (see Result.AnnotateURI).
+// Annotations already present on an entry are kept unless gocue produced a
+// value for the same key; entries without a result are written unchanged.
+func WriteAnnotatedM3U(w io.Writer, entries []PlaylistEntry, results []*Result) error {
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString("#EXTM3U\n")
+	for i, entry := range entries {
+		var res *Result
+		if i < len(results) {
+			res = results[i]
+		}
+		line, err := annotateLine(entry, res)
+		if err != nil {
+			return err
+		}
+		_, _ = bw.WriteString(line + "\n")
+	}
+	return bw.Flush()
+}
+
+func annotateLine(entry PlaylistEntry, res *Result) (string, error) {
+	if res == nil {
+		if len(entry.Annotations) == 0 {
+			return entry.Path, nil
+		}
+		return formatAnnotate(entry.Annotations, entry.Path), nil
+	}
+	annotations, err := res.Annotations()
+	if err != nil {
+		return "", err
+	}
+	for key, val := range entry.Annotations {
+		if _, ok := annotations[key]; !ok {
+			annotations[key] = val
+		}
+	}
+	return formatAnnotate(annotations, entry.Path), nil
+}
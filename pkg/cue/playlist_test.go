@@ -0,0 +1,99 @@
+package cue
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PlaylistSuite struct {
+	suite.Suite
+}
+
+func TestPlaylistSuite(t *testing.T) {
+	suite.Run(t, &PlaylistSuite{})
+}
+
+func (s *PlaylistSuite) TestReadPlaylist() {
+	dir := s.T().TempDir()
+	abs := filepath.Join(dir, "abs.mp3")
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"list.m3u8", "\ufeff#EXTM3U\n#EXTINF:123,Artist - Title\nmusic/one.mp3\n\n" + abs + "\r\nhttp://example.com/stream\n"},
+		{"list.pls", "[playlist]\nFile2=" + abs + "\nFile1=music/one.mp3\nTitle1=One\nFile3=http://example.com/stream\nNumberOfEntries=3\n"},
+		{"list.xspf", `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/"><trackList>
+<track><location>music/one.mp3</location></track>
+<track><location>file://` + filepath.ToSlash(abs) + `</location></track>
+<track><location>http://example.com/stream</location></track>
+</trackList></playlist>`},
+	}
+	for _, tc := range tests {
+		s.Run(tc.name, func() {
+			path := filepath.Join(dir, tc.name)
+			s.Require().NoError(os.WriteFile(path, []byte(tc.content), 0o644))
+			entries, err := ReadPlaylist(path)
+			s.Require().NoError(err)
+			s.Equal([]PlaylistEntry{
+				{Path: filepath.Join(dir, "music", "one.mp3")},
+				{Path: abs},
+				{Path: "http://example.com/stream"},
+			}, entries)
+		})
+	}
+
+	_, err := ReadPlaylist(filepath.Join(dir, "list.txt"))
+	s.Error(err)
+}
+
+func (s *PlaylistSuite) TestReadAnnotatedEntries() {
+	path := filepath.Join(s.T().TempDir(), "list.m3u")
+	s.Require().NoError(os.WriteFile(path, []byte(`annotate:liq_cue_in="2.5",title="A, \"B\": C":/music/a.mp3`+"\n"), 0o644))
+	entries, err := ReadPlaylist(path)
+	s.Require().NoError(err)
+	s.Equal([]PlaylistEntry{{
+		Path:        "/music/a.mp3",
+		Annotations: map[string]string{"liq_cue_in": "2.5", "title": `A, "B": C`},
+	}}, entries)
+}
+
+func (s *PlaylistSuite) TestParseAnnotate() {
+	meta, uri, ok := parseAnnotate(`annotate:a=1,b="x\\y":file.mp3`)
+	s.True(ok)
+	s.Equal(map[string]string{"a": "1", "b": `x\y`}, meta)
+	s.Equal("file.mp3", uri)
+
+	for _, bad := range []string{"file.mp3", `annotate:a="open:file.mp3`, "annotate:a=1"} {
+		_, _, ok := parseAnnotate(bad)
+		s.False(ok, bad)
+	}
+}
+
+func (s *PlaylistSuite) TestWriteAnnotatedM3U() {
+	entries := []PlaylistEntry{
+		{Path: "/music/a.mp3", Annotations: map[string]string{"title": "A", "liq_cue_in": "9.9"}},
+		{Path: "/music/b.mp3"},
+	}
+	var b strings.Builder
+	s.Require().NoError(WriteAnnotatedM3U(&b, entries, []*Result{{CueIn: 1.5, Loudness: `-9 "x"`}, nil}))
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	s.Require().Len(lines, 3)
+	s.Equal("#EXTM3U", lines[0])
+	s.True(strings.HasPrefix(lines[1], `annotate:duration="0.000",`))
+	s.Contains(lines[1], `,liq_cue_in="1.500",`)
+	s.Contains(lines[1], `,liq_loudness="-9 \"x\"",`)
+	s.True(strings.HasSuffix(lines[1], `,title="A":/music/a.mp3`))
+	s.Equal("/music/b.mp3", lines[2])
+
+	// the written URI parses back to the same annotations
+	meta, uri, ok := parseAnnotate(lines[1])
+	s.True(ok)
+	s.Equal("/music/a.mp3", uri)
+	s.Equal(`-9 "x"`, meta["liq_loudness"])
+}
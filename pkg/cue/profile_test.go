@@ -0,0 +1,111 @@
+package cue
+
+import (
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ProfileSuite struct {
+	suite.Suite
+}
+
+func TestProfileSuite(t *testing.T) {
+	suite.Run(t, &ProfileSuite{})
+}
+
+// testProfile returns a track of the given length in seconds: one second of
+// silence, a body around -14 LUFS and a 10 second fade-out into silence.
+func testProfile(seconds int) *profile {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	p := &profile{integrated: -14, tp: NewTruePeakInfo(0.9, 6)}
+	n := seconds * 10
+	for i := range n {
+		t := float64(i) / 10
+		l := -14 + rnd.Float64()*4 - 2
+		switch {
+		case i < 10:
+			l = math.Inf(-1)
+		case t > float64(seconds)-12:
+			l = -14 - (t-float64(seconds)+12)*5
+		}
+		p.frames = append(p.frames, Frame{PTSTime: t, Loudness: l})
+	}
+	return p
+}
+
+func (s *ProfileSuite) TestEncodeProfile() {
+	p := testProfile(7200)
+	encoded, err := encodeProfile(p)
+	s.Require().NoError(err)
+	s.Less(len(encoded), 200_000)
+
+	decoded, err := decodeProfile(encoded)
+	s.Require().NoError(err)
+	s.Equal(p.integrated, decoded.integrated)
+	s.Equal(p.tp, decoded.tp)
+	s.Require().Len(decoded.frames, len(p.frames))
+	for i, f := range p.frames {
+		s.InDelta(f.PTSTime, decoded.frames[i].PTSTime, 0.0005)
+		s.InDelta(max(f.Loudness, profileLoudnessFloor), decoded.frames[i].Loudness, 0.0005)
+	}
+
+	_, err = decodeProfile(encoded[:len(encoded)/2])
+	s.Error(err)
+	_, err = decodeProfile([]byte("GCP1"))
+	s.Error(err)
+	s.Nil(decoded.beats)
+
+	p.beats = &BeatGrid{BPM: 127.5, FirstBeat: 0.21, FirstDownbeat: 1.62}
+	encoded, err = encodeProfile(p)
+	s.Require().NoError(err)
+	decoded, err = decodeProfile(encoded)
+	s.Require().NoError(err)
+	s.Equal(p.beats, decoded.beats)
+	s.Len(decoded.frames, len(p.frames))
+}
+
+// TestCalcStoredProfile checks that a result for other thresholds is computed
+// from the stored profile, without ffmpeg.
+func (s *ProfileSuite) TestCalcStoredProfile() {
+	p := testProfile(240)
+	encoded, err := encodeProfile(p)
+	s.Require().NoError(err)
+
+	for _, store := range []string{"sidecar", "cache"} {
+		s.Run(store, func() {
+			dir := s.T().TempDir()
+			path := filepath.Join(dir, "a.aac")
+			s.Require().NoError(os.WriteFile(path, mp3Frames, 0o644))
+
+			c := NewCalculator(nil)
+			old := c.analyze(p)
+			switch store {
+			case "sidecar":
+				c.sidecar = true
+				s.Require().NoError(c.writeSidecar(path, old, encoded))
+			case "cache":
+				cache, err := OpenCache(filepath.Join(dir, "cache.db"))
+				s.Require().NoError(err)
+				c.cache = cache
+				s.Require().NoError(c.cache.store(path, c.paramsFingerprint(), "", old, encoded))
+			}
+
+			c.overlay = -20
+			res, err := c.Calc(path)
+			s.Require().NoError(err)
+			s.False(res.Cached())
+			s.Equal(c.analyze(p), res)
+			s.Less(old.CrossStartNext, res.CrossStartNext)
+
+			// and stored for the new settings
+			res, err = c.Calc(path)
+			s.Require().NoError(err)
+			s.True(res.Cached())
+		})
+	}
+}
@@ -3,7 +3,9 @@ package cue
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -26,6 +28,27 @@ type Result struct {
 	BlankSkipped      bool    `json:"liq_blank_skipped" yaml:"liq_blank_skipped"`
 	TruePeak          float64 `json:"liq_true_peak" yaml:"liq_true_peak"`
 	TruePeakDb        string  `json:"liq_true_peak_db" yaml:"liq_true_peak_db"`
+	CrossDuration     float64 `json:"liq_cross_duration" yaml:"liq_cross_duration"`
+	FadeIn            float64 `json:"liq_fade_in" yaml:"liq_fade_in"`
+	FadeOut           float64 `json:"liq_fade_out" yaml:"liq_fade_out"`
+	IntroEnd          float64 `json:"liq_intro_end" yaml:"liq_intro_end"`
+	Ending            string  `json:"liq_ending" yaml:"liq_ending"`
+	// beat grid, with CalculatorOptions.Beats and a steady beat; times are
+	// pointers, as 0 is a common value for them
+	BPM           float64  `json:"liq_bpm,omitempty" yaml:"liq_bpm,omitempty"`
+	FirstBeat     *float64 `json:"liq_first_beat,omitempty" yaml:"liq_first_beat,omitempty"`
+	FirstDownbeat *float64 `json:"liq_first_downbeat,omitempty" yaml:"liq_first_downbeat,omitempty"`
+	// cue points before snapping them to the beat grid, with CalculatorOptions.Snap
+	CueInRaw          *float64 `json:"liq_cue_in_raw,omitempty" yaml:"liq_cue_in_raw,omitempty"`
+	CrossStartNextRaw *float64 `json:"liq_cross_start_next_raw,omitempty" yaml:"liq_cross_start_next_raw,omitempty"`
+
+	// set when the values were read from existing tags instead of analysed
+	cached bool
+}
+
+// Cached - reports whether the result was read from existing tags, skipping a full analysis
+func (r *Result) Cached() bool {
+	return r.cached
 }
 
 // MarshalYAML - returns yaml
@@ -43,6 +66,62 @@ func (r *Result) MarshalNiceJSON() (out []byte, err error) {
 	return json.MarshalIndent(*r, " ", " ")
 }
 
+// ResultColumns - names of the Result fields in declaration order, as used in
+// JSON and YAML; the header of tabular (CSV/TSV) output. New fields go at the
+// end of Result, so the columns of earlier versions keep their place.
+func ResultColumns() []string {
+	t := reflect.TypeFor[Result]()
+	cols := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		if name := columnName(t.Field(i)); name != "" {
+			cols = append(cols, name)
+		}
+	}
+	return cols
+}
+
+// Row - Result field values in ResultColumns order, formatted like Annotations;
+// unset optional values are empty
+func (r *Result) Row() []string {
+	v := reflect.ValueOf(*r)
+	row := make([]string, 0, v.NumField())
+	for i := range v.NumField() {
+		if columnName(v.Type().Field(i)) == "" {
+			continue
+		}
+		row = append(row, cell(v.Field(i)))
+	}
+	return row
+}
+
+// cell formats a field value of a Row.
+func cell(f reflect.Value) string {
+	switch f.Kind() {
+	case reflect.Float64:
+		return fmt.Sprintf("%.3f", f.Float())
+	case reflect.Bool:
+		return strconv.FormatBool(f.Bool())
+	case reflect.Pointer:
+		if f.IsNil() {
+			return ""
+		}
+		return cell(f.Elem())
+	}
+	return f.String()
+}
+
+// columnName returns the JSON name of an exported field, or "" if it is not serialised
+func columnName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
 // Annotations - unmarshaled JSON as map of strings
 func (r *Result) Annotations() (out map[string]string, err error) {
 	// marshal annotations into bytes
@@ -74,14 +153,17 @@ func (r *Result) Annotations() (out map[string]string, err error) {
 }
 
 // parseTags builds a Result from existing file tags (the cached/fast path that
-// skips a full ffmpeg analysis). Kept in sync with the scan() path so both
-// produce identical output.
+// skips a full ffmpeg analysis). Kept in sync with analyze so both produce
+// identical output.
 func parseTags(tags map[string]string) *Result {
 	duration, _ := strconv.ParseFloat(tags["duration"], 64)
 	cueDuration, _ := strconv.ParseFloat(tags["liq_cue_duration"], 64)
 	cueIn, _ := strconv.ParseFloat(tags["liq_cue_in"], 64)
+	introEnd, _ := strconv.ParseFloat(tags["liq_intro_end"], 64)
 	cueOut, _ := strconv.ParseFloat(tags["liq_cue_out"], 64)
 	crossStartNext, _ := strconv.ParseFloat(tags["liq_cross_start_next"], 64)
+	fadeIn, _ := strconv.ParseFloat(tags["liq_fade_in"], 64)
+	fadeOut, _ := strconv.ParseFloat(tags["liq_fade_out"], 64)
 	longtail := tags["liq_longtail"] == "true"
 	sustainedEnding := tags["liq_sustained_ending"] == "true"
 	blankSkip, _ := strconv.ParseFloat(tags["liq_blankskip"], 64)
@@ -93,14 +175,20 @@ func parseTags(tags map[string]string) *Result {
 	amplify, _ := strconv.ParseFloat(tags["liq_amplify"], 64)
 	amplifyCorrection, _ := strconv.ParseFloat(tags["liq_amplify_adjustment"], 64)
 	referenceLoudness, _ := strconv.ParseFloat(tags["liq_reference_loudness"], 64)
+	bpm, _ := strconv.ParseFloat(tags["liq_bpm"], 64)
 	return &Result{
 		Duration:          duration,
 		CueDuration:       cueDuration,
 		CueIn:             cueIn,
+		IntroEnd:          introEnd,
 		CueOut:            cueOut,
 		CrossStartNext:    crossStartNext,
+		CrossDuration:     cueOut - crossStartNext,
+		FadeIn:            fadeIn,
+		FadeOut:           fadeOut,
 		LongTail:          longtail,
 		SustainedEnding:   sustainedEnding,
+		Ending:            tags["liq_ending"],
 		Loudness:          fmt.Sprintf("%.3f LUFS", loudness),
 		LoudnessRange:     fmt.Sprintf("%.3f LU", loudnessRange),
 		Amplify:           fmt.Sprintf("%.3f dB", amplify),
@@ -110,5 +198,19 @@ func parseTags(tags map[string]string) *Result {
 		BlankSkipped:      blankSkipped,
 		TruePeak:          truePeak,
 		TruePeakDb:        fmt.Sprintf("%.3f dBFS", truePeakDb),
+		BPM:               bpm,
+		FirstBeat:         optionalTag(tags, "liq_first_beat"),
+		FirstDownbeat:     optionalTag(tags, "liq_first_downbeat"),
+		CueInRaw:          optionalTag(tags, "liq_cue_in_raw"),
+		CrossStartNextRaw: optionalTag(tags, "liq_cross_start_next_raw"),
+	}
+}
+
+// optionalTag returns the numeric value of the tag, nil if it is missing.
+func optionalTag(tags map[string]string, key string) *float64 {
+	val, err := strconv.ParseFloat(tags[key], 64)
+	if err != nil {
+		return nil
 	}
+	return &val
 }
@@ -1,6 +1,8 @@
 package cue
 
 import (
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -18,6 +20,7 @@ func (s *ResultSuite) TestAnnotations() {
 	result := &Result{
 		Duration:          101.1,
 		CueIn:             4.2,
+		IntroEnd:          12.3,
 		CueOut:            95.54,
 		CrossStartNext:    92.2,
 		Amplify:           "-25.5 dB",
@@ -29,6 +32,7 @@ func (s *ResultSuite) TestAnnotations() {
 		TruePeak:          -0.57,
 		CueDuration:       91.34,
 		SustainedEnding:   true,
+		Ending:            EndingSustained,
 		BlankSkip:         0.0,
 	}
 
@@ -40,10 +44,15 @@ func (s *ResultSuite) TestAnnotations() {
 		"liq_amplify_adjustment": "-10.1 dB",
 		"liq_blank_skipped":      "false",
 		"liq_blankskip":          "0.000",
+		"liq_cross_duration":     "0.000",
 		"liq_cross_start_next":   "92.200",
 		"liq_cue_duration":       "91.340",
 		"liq_cue_in":             "4.200",
 		"liq_cue_out":            "95.540",
+		"liq_ending":             "sustained",
+		"liq_fade_in":            "0.000",
+		"liq_fade_out":           "0.000",
+		"liq_intro_end":          "12.300",
 		"liq_longtail":           "false",
 		"liq_loudness":           "-4.57 LU",
 		"liq_loudness_range":     "12 LUFS",
@@ -52,4 +61,49 @@ func (s *ResultSuite) TestAnnotations() {
 		"liq_true_peak":          "-0.570",
 		"liq_true_peak_db":       "-1.200 dBFS",
 	}, a)
+
+	result.BPM, result.FirstBeat, result.FirstDownbeat = 128, new(0.25), new(2.125)
+	result.CrossStartNextRaw = new(92.4)
+	a, err = result.Annotations()
+	s.NoError(err)
+	s.Equal("128.000", a["liq_bpm"])
+	s.Equal("0.250", a["liq_first_beat"])
+	s.Equal("2.125", a["liq_first_downbeat"])
+	s.Equal("92.400", a["liq_cross_start_next_raw"])
+	s.NotContains(a, "liq_cue_in_raw")
+}
+
+func (s *ResultSuite) TestAnnotateURI() {
+	uri, err := (&Result{Duration: 10, CueOut: 9.5}).AnnotateURI("/music/a.mp3")
+	s.NoError(err)
+	s.True(strings.HasPrefix(uri, `annotate:duration="10.000",liq_amplify="",`))
+	s.True(strings.HasSuffix(uri, `,liq_true_peak_db="":/music/a.mp3`))
+
+	s.Equal(`annotate:a="x\\y",b="say \"hi\", then: go":/music/a b.mp3`,
+		formatAnnotate(map[string]string{"b": `say "hi", then: go`, "a": `x\y`}, "/music/a b.mp3"))
+
+	meta, path, ok := parseAnnotate(uri)
+	s.True(ok)
+	s.Equal("/music/a.mp3", path)
+	s.Equal("9.500", meta["liq_cue_out"])
+}
+
+func (s *ResultSuite) TestRow() {
+	cols := ResultColumns()
+	// the columns of earlier versions keep their place
+	s.Equal([]string{
+		"duration", "liq_cue_duration", "liq_cue_in", "liq_cue_out", "liq_cross_start_next",
+		"liq_longtail", "liq_sustained_ending", "liq_loudness", "liq_loudness_range",
+		"liq_amplify", "liq_amplify_adjustment", "liq_reference_loudness",
+		"liq_blankskip", "liq_blank_skipped", "liq_true_peak", "liq_true_peak_db",
+		"liq_cross_duration", "liq_fade_in", "liq_fade_out", "liq_intro_end", "liq_ending",
+	}, cols[:21])
+	s.NotContains(cols, "cached")
+
+	row := (&Result{Duration: 12.5, LongTail: true, Amplify: "-1.00 dB"}).Row()
+	s.Len(row, len(cols))
+	s.Equal("12.500", row[slices.Index(cols, "duration")])
+	s.Equal("true", row[slices.Index(cols, "liq_longtail")])
+	s.Equal("-1.00 dB", row[slices.Index(cols, "liq_amplify")])
+	s.Empty(row[slices.Index(cols, "liq_cue_in_raw")])
 }
@@ -0,0 +1,252 @@
+package cue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	riffID = []byte("RIFF")
+	waveID = []byte("WAVE")
+	// chunk id ffmpeg's WAV demuxer reads an ID3v2 tag from (either case)
+	id3ChunkID = []byte("id3 ")
+)
+
+// riffChunk - location of a single chunk inside a RIFF file
+type riffChunk struct {
+	id     string
+	offset int64 // offset of the chunk header
+	size   int64 // header + payload + pad byte
+}
+
+// writeWAVTags stores tags as TXXX frames of an ID3v2 "id3 " chunk, keeping
+// the other frames of an existing chunk. When the file has no such chunk yet,
+// or it is the last one, the new chunk is written in place at the end of the
+// file; otherwise the file is rewritten without the old chunk.
+func writeWAVTags(pathToFile string, tags map[string]string) error {
+	f, err := os.OpenFile(pathToFile, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	chunks, err := readRIFFChunks(f)
+	if err != nil {
+		return fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+
+	var (
+		keep    []riffChunk
+		oldTag  *riffChunk
+		fileEnd int64 = 12
+	)
+	for i := range chunks {
+		if bytes.EqualFold([]byte(chunks[i].id), id3ChunkID) {
+			oldTag = &chunks[i]
+			continue
+		}
+		keep = append(keep, chunks[i])
+		fileEnd = max(fileEnd, chunks[i].offset+chunks[i].size)
+	}
+
+	t := &id3Tag{version: 4}
+	if oldTag != nil {
+		if t, err = readID3Tag(io.NewSectionReader(f, oldTag.offset+8, oldTag.size-8)); err != nil {
+			return fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+		}
+	}
+	t.set(tags)
+	chunk := encodeID3Chunk(t)
+
+	if oldTag == nil || oldTag.offset >= fileEnd {
+		if _, err := f.WriteAt(chunk, fileEnd); err != nil {
+			return err
+		}
+		if err := f.Truncate(fileEnd + int64(len(chunk))); err != nil {
+			return err
+		}
+		return writeRIFFSize(f, fileEnd+int64(len(chunk)))
+	}
+	return rewriteRIFF(pathToFile, f, keep, chunk)
+}
+
+// readRIFFChunks lists the top-level chunks of a RIFF/WAVE file.
+func readRIFFChunks(r io.ReadSeeker) ([]riffChunk, error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hdr[0:4], riffID) || !bytes.Equal(hdr[8:12], waveID) {
+		return nil, errors.New("not a RIFF/WAVE file")
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []riffChunk
+	for offset := int64(12); offset+8 <= end; {
+		var ch [8]byte
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, ch[:]); err != nil {
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+		total := 8 + size + size%2
+		// tolerate a truncated final chunk (common for streamed recordings)
+		total = min(total, end-offset)
+		chunks = append(chunks, riffChunk{id: string(ch[0:4]), offset: offset, size: total})
+		offset += total
+	}
+	return chunks, nil
+}
+
+// rewriteRIFF copies the kept chunks of src plus the new tag chunk into a
+// temporary sibling file and replaces the original with it.
+func rewriteRIFF(pathToFile string, src io.ReaderAt, keep []riffChunk, tagChunk []byte) error {
+	tmp, err := siblingTempFile(pathToFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	size := int64(12)
+	if _, err = dst.Write(append(append([]byte{}, riffID...), 0, 0, 0, 0, 'W', 'A', 'V', 'E')); err == nil {
+		for _, ch := range keep {
+			if _, err = io.Copy(dst, io.NewSectionReader(src, ch.offset, ch.size)); err != nil {
+				break
+			}
+			size += ch.size
+		}
+	}
+	if err == nil {
+		_, err = dst.Write(tagChunk)
+		size += int64(len(tagChunk))
+	}
+	if err == nil {
+		err = writeRIFFSize(dst, size)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return replaceFile(pathToFile, tmp)
+}
+
+// writeRIFFSize updates the RIFF header size field for a file of fileSize bytes.
+func writeRIFFSize(w io.WriterAt, fileSize int64) error {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(fileSize-8))
+	_, err := w.WriteAt(b[:], 4)
+	return err
+}
+
+// encodeID3Chunk builds a padded RIFF "id3 " chunk holding the ID3v2 tag t.
+func encodeID3Chunk(t *id3Tag) []byte {
+	tag := t.encode(0)
+	chunk := append(append([]byte{}, id3ChunkID...), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:8], uint32(len(tag)))
+	chunk = append(chunk, tag...)
+	if len(tag)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// readWAVTags returns the TXXX frames of the ID3v2 "id3 " chunk of a WAV file.
+func readWAVTags(pathToFile string) (map[string]string, error) {
+	f, err := os.Open(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	chunks, err := readRIFFChunks(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+	}
+	for _, ch := range chunks {
+		if bytes.EqualFold([]byte(ch.id), id3ChunkID) {
+			t, err := readID3Tag(io.NewSectionReader(f, ch.offset+8, ch.size-8))
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse %q: %w", pathToFile, err)
+			}
+			return t.txxx(), nil
+		}
+	}
+	return map[string]string{}, nil
+}
+
+// WAVE format tags of uncompressed audio; WAVE_FORMAT_EXTENSIBLE carries one
+// of them in the first two bytes of its subformat GUID
+const (
+	wavePCM        = 0x0001
+	waveFloat      = 0x0003
+	waveExtensible = 0xfffe
+)
+
+// openWAV returns the audio of a WAV file holding integer or float PCM.
+func openWAV(r pcmFile) (*pcmStream, error) {
+	chunks, err := readRIFFChunks(r)
+	if err != nil {
+		return nil, err
+	}
+	var format, data *riffChunk
+	for i := range chunks {
+		switch chunks[i].id {
+		case "fmt ":
+			format = &chunks[i]
+		case "data":
+			if data == nil {
+				data = &chunks[i]
+			}
+		}
+	}
+	if format == nil || data == nil {
+		return nil, errors.New("missing WAV fmt or data chunk")
+	}
+
+	b := make([]byte, min(format.size-8, 40))
+	if _, err := r.ReadAt(b, format.offset+8); err != nil {
+		return nil, err
+	}
+	if len(b) < 16 {
+		return nil, errors.New("truncated WAV fmt chunk")
+	}
+	tag := binary.LittleEndian.Uint16(b[0:2])
+	channels := int(binary.LittleEndian.Uint16(b[2:4]))
+	sampleRate := int(binary.LittleEndian.Uint32(b[4:8]))
+	blockAlign := int(binary.LittleEndian.Uint16(b[12:14]))
+	if tag == waveExtensible {
+		if len(b) < 40 {
+			return nil, errors.New("truncated WAVE_FORMAT_EXTENSIBLE fmt chunk")
+		}
+		tag = binary.LittleEndian.Uint16(b[24:26])
+	}
+	if tag != wavePCM && tag != waveFloat {
+		return nil, fmt.Errorf("unsupported WAV encoding 0x%04x", tag)
+	}
+	if channels == 0 || blockAlign%channels != 0 {
+		return nil, fmt.Errorf("invalid WAV block size %d for %d channels", blockAlign, channels)
+	}
+	size := blockAlign / channels
+	s, err := newPCMStream(io.NewSectionReader(r, data.offset+8, data.size-8), sampleRate, channels,
+		sampleFormat{size: size, float: tag == waveFloat, unsigned: size == 1})
+	if err != nil {
+		return nil, err
+	}
+	s.frames = (data.size - 8) / int64(blockAlign)
+	return s, nil
+}
@@ -0,0 +1,174 @@
+package cue
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// file name suffix of sidecar files
+const sidecarExt = ".gocue.json"
+
+// sidecarFile - the content of a sidecar file: an analysis result, the
+// settings it was computed with and the audio file it belongs to
+type sidecarFile struct {
+	// size and modification time of the audio file; a sidecar is stale once
+	// they change
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// version of gocue and fingerprint of the cue-affecting settings, as in
+	// the liq_gocue_version and liq_gocue_params tags
+	Version     string        `json:"version,omitempty"`
+	Fingerprint string        `json:"fingerprint"`
+	Params      sidecarParams `json:"params"`
+	Result      *Result       `json:"result"`
+	// encoded loudness profile, to recompute the result for other settings
+	Profile []byte `json:"profile,omitempty"`
+}
+
+// sidecarParams - the analysis settings stored along with a sidecar result
+type sidecarParams struct {
+	TargetLoudness  float64 `json:"target"`
+	Silence         float64 `json:"silence"`
+	Overlay         float64 `json:"overlay"`
+	Intro           float64 `json:"intro"`
+	LongtailSeconds float64 `json:"longtail"`
+	Extra           float64 `json:"extra"`
+	Drop            float64 `json:"drop"`
+	BlankSkip       float64 `json:"blankskip"`
+	NoClip          bool    `json:"noclip"`
+	Beats           bool    `json:"beats"`
+	Snap            string  `json:"snap"`
+	SnapCueIn       bool    `json:"snap_cue_in"`
+}
+
+// sidecarPath returns the sidecar file of pathToFile: next to it, or below
+// sidecarDir at the file's absolute path if that is set.
+func (c *Calculator) sidecarPath(pathToFile string) (string, error) {
+	if c.sidecarDir == "" {
+		return pathToFile + sidecarExt, nil
+	}
+	abs, err := filepath.Abs(pathToFile)
+	if err != nil {
+		return "", err
+	}
+	// drop the volume name, so Windows paths mirror as well
+	abs = abs[len(filepath.VolumeName(abs)):]
+	return filepath.Join(c.sidecarDir, abs) + sidecarExt, nil
+}
+
+// loadSidecar returns the sidecar file of pathToFile; a missing or stale
+// sidecar yields nil and no error.
+func (c *Calculator) loadSidecar(pathToFile string) (*sidecarFile, error) {
+	path, err := c.sidecarPath(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sc sidecarFile
+	if err := json.Unmarshal(raw, &sc); err != nil {
+		return nil, fmt.Errorf("cannot parse sidecar %q: %w", path, err)
+	}
+	info, err := os.Stat(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	if sc.Result == nil || sc.Size != info.Size() || !sc.ModTime.Equal(info.ModTime()) {
+		return nil, nil
+	}
+	return &sc, nil
+}
+
+// readSidecar returns the tags stored in the sidecar file of pathToFile, in
+// the same form as the file tags written by WriteTags. A missing or stale
+// sidecar yields no tags and no error.
+func (c *Calculator) readSidecar(pathToFile string) (map[string]string, error) {
+	sc, err := c.loadSidecar(pathToFile)
+	if sc == nil {
+		return nil, err
+	}
+	tags, err := cachedTags(sc.Result)
+	if err != nil {
+		return nil, err
+	}
+	if sc.Fingerprint != "" {
+		tags["liq_gocue_params"] = sc.Fingerprint
+	}
+	if sc.Version != "" {
+		tags["liq_gocue_version"] = sc.Version
+	}
+	return tags, nil
+}
+
+// WriteSidecar - stores res with the current analysis settings in the
+// sidecar file of pathToFile, for audio files that cannot be tagged (e.g. on
+// read-only mounts). The sidecar directory tree is created as needed.
+func (c *Calculator) WriteSidecar(pathToFile string, res *Result) error {
+	return c.writeSidecar(pathToFile, res, nil)
+}
+
+// writeSidecar is WriteSidecar, also storing an encoded loudness profile.
+func (c *Calculator) writeSidecar(pathToFile string, res *Result, profile []byte) error {
+	path, err := c.sidecarPath(pathToFile)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(pathToFile)
+	if err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(sidecarFile{
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		Version:     c.appVersion,
+		Fingerprint: c.paramsFingerprint(),
+		Params: sidecarParams{
+			TargetLoudness:  c.targetLoudness,
+			Silence:         c.silence,
+			Overlay:         c.overlay,
+			Intro:           c.intro,
+			LongtailSeconds: c.longtailSeconds,
+			Extra:           c.extra,
+			Drop:            c.drop,
+			BlankSkip:       c.blankSkip,
+			NoClip:          c.noClip,
+			Beats:           c.beats,
+			Snap:            c.snap.String(),
+			SnapCueIn:       c.snapCueIn,
+		},
+		Result:  res,
+		Profile: profile,
+	}, "", " ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// write a temporary sibling first, so readers never see a partial file
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot create sidecar for %q: %w", pathToFile, err)
+	}
+	tmp := f.Name()
+	defer func() { _ = os.Remove(tmp) }()
+	if _, err = f.Write(append(raw, '\n')); err == nil {
+		err = f.Chmod(0o644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,141 @@
+package cue
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SidecarSuite struct {
+	suite.Suite
+}
+
+func TestSidecarSuite(t *testing.T) {
+	suite.Run(t, &SidecarSuite{})
+}
+
+var sidecarResult = Result{
+	Duration:          180.5,
+	CueDuration:       176.8,
+	CueIn:             1.2,
+	CueOut:            178.0,
+	CrossStartNext:    174.0,
+	CrossDuration:     4.0,
+	FadeIn:            0.1,
+	FadeOut:           3.0,
+	Loudness:          "-14.000 LUFS",
+	LoudnessRange:     "6.000 LU",
+	Amplify:           "-4.000 dB",
+	AmplifyAdjustment: "0.000 dB",
+	ReferenceLoudness: "-18.000 LUFS",
+	TruePeak:          0.9,
+	TruePeakDb:        "-0.915 dBFS",
+}
+
+func (s *SidecarSuite) TestSidecarPath() {
+	c := NewCalculator(&CalculatorOptions{Sidecar: true})
+	path, err := c.sidecarPath("music/a.flac")
+	s.Require().NoError(err)
+	s.Equal("music/a.flac.gocue.json", path)
+
+	dir := s.T().TempDir()
+	c = NewCalculator(&CalculatorOptions{SidecarDir: dir})
+	s.True(c.sidecar)
+	abs, err := filepath.Abs("music/a.flac")
+	s.Require().NoError(err)
+	path, err = c.sidecarPath("music/a.flac")
+	s.Require().NoError(err)
+	s.Equal(filepath.Join(dir, abs+".gocue.json"), path)
+}
+
+func (s *SidecarSuite) TestCalcSidecar() {
+	for _, mirror := range []bool{false, true} {
+		s.Run(map[bool]string{false: "next to the file", true: "mirrored"}[mirror], func() {
+			tmp := s.T().TempDir()
+			audio := filepath.Join(tmp, "a.aac")
+			s.Require().NoError(os.WriteFile(audio, mp3Frames, 0o444))
+
+			c := NewCalculator(nil)
+			c.sidecar = true
+			if mirror {
+				c.sidecarDir = filepath.Join(tmp, "cache")
+			}
+			res := sidecarResult
+			s.Require().NoError(c.WriteSidecar(audio, &res))
+			path, err := c.sidecarPath(audio)
+			s.Require().NoError(err)
+			s.FileExists(path)
+
+			// no ffprobe needed: the sidecar replaces the file tags
+			got, err := c.CalcWithMetadata(audio, map[string]string{"liq_cue_in": "2.0"})
+			s.Require().NoError(err)
+			s.True(got.Cached())
+			s.InDelta(2.0, got.CueIn, 1e-9)
+			s.InDelta(178.0, got.CueOut, 1e-9)
+			s.InDelta(3.0, got.FadeOut, 1e-9)
+			s.Equal("-4.000 dB", got.Amplify)
+		})
+	}
+}
+
+func (s *SidecarSuite) TestSidecarParams() {
+	audio := filepath.Join(s.T().TempDir(), "a.aac")
+	s.Require().NoError(os.WriteFile(audio, mp3Frames, 0o644))
+
+	opts := DefaultCalculatorOptions()
+	opts.Intro = -4
+	opts.Snap, opts.SnapCueIn = SnapBar, true
+	c := NewCalculator(&opts)
+	res := sidecarResult
+	s.Require().NoError(c.WriteSidecar(audio, &res))
+	sc, err := c.loadSidecar(audio)
+	s.Require().NoError(err)
+	s.Equal(sidecarParams{
+		TargetLoudness:  -18,
+		Silence:         -42,
+		Overlay:         -8,
+		Intro:           -4,
+		LongtailSeconds: 15,
+		Extra:           -12,
+		Drop:            40,
+		Beats:           true,
+		Snap:            "bar",
+		SnapCueIn:       true,
+	}, sc.Params)
+}
+
+func (s *SidecarSuite) TestStaleSidecar() {
+	audio := filepath.Join(s.T().TempDir(), "a.aac")
+	s.Require().NoError(os.WriteFile(audio, mp3Frames, 0o644))
+
+	c := NewCalculator(&CalculatorOptions{Sidecar: true, Silence: -42, Overlay: -8, AppVersion: "1.2.3"})
+	res := sidecarResult
+	s.Require().NoError(c.WriteSidecar(audio, &res))
+	tags, err := c.readSidecar(audio)
+	s.Require().NoError(err)
+	s.Equal(c.paramsFingerprint(), tags["liq_gocue_params"])
+	s.Equal("1.2.3", tags["liq_gocue_version"])
+	s.Equal("-4.000 dB", tags["replaygain_track_gain"])
+
+	// other thresholds reject it
+	collected := map[string]string{}
+	c.collectTags(collected, tags)
+	s.NoError(c.doPreAnalysis(maps.Clone(collected)))
+	c.overlay = -6
+	s.IsType(ErrRequireAnalysis{}, c.doPreAnalysis(collected))
+
+	// a changed audio file invalidates the sidecar
+	later := time.Now().Add(time.Minute)
+	s.Require().NoError(os.Chtimes(audio, later, later))
+	tags, err = c.readSidecar(audio)
+	s.NoError(err)
+	s.Nil(tags)
+
+	s.Require().NoError(os.WriteFile(audio+sidecarExt, []byte("{"), 0o644))
+	_, err = c.readSidecar(audio)
+	s.Error(err)
+}
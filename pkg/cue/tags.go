@@ -0,0 +1,243 @@
+package cue
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// extra ffmpeg muxer arguments needed to persist custom (non-standard) tags,
+// keyed by lower-cased file extension. A missing key means tag writing is not
+// supported through ffmpeg for that container.
+var tagWriteMuxerArgs = map[string][]string{
+	".aif":  {"-write_id3v2", "1"},
+	".aiff": {"-write_id3v2", "1"},
+}
+
+// native tag writers that edit the file without an ffmpeg remux, keyed by
+// lower-cased file extension
+var nativeTagWriters = map[string]func(pathToFile string, tags map[string]string) error{
+	// ffmpeg's WAV muxer only keeps standard RIFF INFO keys, so custom tags
+	// go into an ID3v2 "id3 " chunk, which ffprobe reads back
+	".wav":  writeWAVTags,
+	".mp3":  writeMP3Tags,
+	".flac": writeFLACTags,
+	".ogg":  writeOggTags,
+	".oga":  writeOggTags,
+	".opus": writeOggTags,
+	".m4a":  writeMP4Tags,
+	".mp4":  writeMP4Tags,
+}
+
+// native tag readers, keyed by lower-cased file extension; they return the
+// raw tags (TXXX frames, Vorbis comments) with their original key case
+var nativeTagReaders = map[string]func(pathToFile string) (map[string]string, error){
+	".wav":  readWAVTags,
+	".aif":  readAIFFTags,
+	".aiff": readAIFFTags,
+	".mp3":  readMP3Tags,
+	".flac": readFLACTags,
+	".ogg":  readOggTags,
+	".oga":  readOggTags,
+	".opus": readOggTags,
+	".m4a":  readMP4Tags,
+	".mp4":  readMP4Tags,
+}
+
+// WriteTags - persists the verifyTags subset of res into the audio file at
+// pathToFile, so a later Calc can take the cached fast path. With the
+// WriteReplayGain option ReplayGain tags are written as well; the liq_* tags
+// are then only included if WriteTags is set too.
+func (c *Calculator) WriteTags(pathToFile string, res *Result) error {
+	return c.WriteTagsContext(context.Background(), pathToFile, res)
+}
+
+// WriteTagsContext - like WriteTags, but a running ffmpeg is killed once ctx
+// is done; the original file is then left untouched
+func (c *Calculator) WriteTagsContext(ctx context.Context, pathToFile string, res *Result) error {
+	tags := map[string]string{}
+	if c.writeTags || !c.writeReplayGain {
+		liqTags, err := resultTags(res)
+		if err != nil {
+			return err
+		}
+		maps.Copy(tags, liqTags)
+		// the settings the cue points were derived from, so that stale tags
+		// can be told apart
+		tags["liq_gocue_params"] = c.paramsFingerprint()
+		if c.appVersion != "" {
+			tags["liq_gocue_version"] = c.appVersion
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(pathToFile))
+	if c.writeReplayGain {
+		rgTags, err := c.replayGainTags(res, isOpus(pathToFile))
+		if err != nil {
+			return err
+		}
+		if ext == ".m4a" || ext == ".mp4" {
+			// iTunes-style freeform atoms conventionally use lower-case names
+			for key, val := range rgTags {
+				tags[strings.ToLower(key)] = val
+			}
+		} else {
+			maps.Copy(tags, rgTags)
+		}
+	}
+	if write, ok := nativeTagWriters[ext]; ok {
+		return write(pathToFile, tags)
+	}
+	muxerArgs, ok := tagWriteMuxerArgs[ext]
+	if !ok {
+		return ErrUnsupportedFormat{ext: ext}
+	}
+	return c.remuxWithTags(ctx, pathToFile, tags, muxerArgs)
+}
+
+// resultTags converts a Result into the file tags that WriteTags persists.
+// Values keep their units (e.g. "-7.530 dB"), the same as the Python autocue
+// writes them; takePureValue strips them again on reading.
+func resultTags(res *Result) (map[string]string, error) {
+	annotations, err := res.Annotations()
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(annotations))
+	for key, val := range annotations {
+		if slices.Contains(verifyTags, key) {
+			tags[key] = val
+		}
+	}
+	return tags, nil
+}
+
+// replayGainTags derives the ReplayGain 2.0 tags from res, following the
+// upper-case key and value formatting used by loudgain. The track gain is
+// liq_amplify, so clipping prevention is honoured the same way as loudgain -k.
+func (c *Calculator) replayGainTags(res *Result, opus bool) (map[string]string, error) {
+	var vals [3]float64
+	for i, v := range [][2]string{
+		{"liq_amplify", res.Amplify},
+		{"liq_loudness_range", res.LoudnessRange},
+		{"liq_reference_loudness", res.ReferenceLoudness},
+	} {
+		clean, err := takePureValue(v[0], v[1])
+		if err != nil {
+			return nil, err
+		}
+		if vals[i], err = strconv.ParseFloat(clean, 64); err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", v[0], err)
+		}
+	}
+	gain, lra, reference := vals[0], vals[1], vals[2]
+
+	tags := map[string]string{
+		"REPLAYGAIN_TRACK_GAIN":         fmt.Sprintf("%.2f dB", gain),
+		"REPLAYGAIN_TRACK_PEAK":         fmt.Sprintf("%.6f", res.TruePeak),
+		"REPLAYGAIN_TRACK_RANGE":        fmt.Sprintf("%.2f dB", lra),
+		"REPLAYGAIN_REFERENCE_LOUDNESS": fmt.Sprintf("%.2f LUFS", reference),
+	}
+	if opus {
+		// RFC 7845: Q7.8 fixed point gain relative to -23 LUFS; the inverse of
+		// the conversion in adjustLoudness
+		r128 := math.Round((gain - (c.targetLoudness - -23.0)) * 256)
+		tags["R128_TRACK_GAIN"] = strconv.Itoa(int(max(math.MinInt16, min(math.MaxInt16, r128))))
+	}
+	return tags, nil
+}
+
+// isOpus reports whether the file is an Ogg Opus stream, whatever its
+// extension (.opus, .ogg or .oga).
+func isOpus(pathToFile string) bool {
+	f, err := os.Open(pathToFile)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+	// the first Ogg page has a 27 byte header and a single lacing value,
+	// followed by the identification packet
+	var hdr [36]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		return false
+	}
+	return string(hdr[0:4]) == "OggS" && string(hdr[28:36]) == "OpusHead"
+}
+
+// remuxWithTags stream-copies the file through ffmpeg into a temporary sibling
+// with the new tags and then atomically replaces the original.
+func (c *Calculator) remuxWithTags(ctx context.Context, pathToFile string, tags map[string]string, muxerArgs []string) error {
+	tmp, err := siblingTempFile(pathToFile)
+	if err != nil {
+		return err
+	}
+	// remove the temporary copy unless it has been renamed over the original
+	defer func() { _ = os.Remove(tmp) }()
+
+	args := []string{
+		"-v", "error",
+		"-nostdin",
+		"-y",
+		"-i", pathToFile,
+		"-map", "0",
+		"-c", "copy",
+		"-map_metadata", "0",
+	}
+	// sorted for a deterministic command line
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "-metadata", key+"="+tags[key])
+	}
+	args = append(args, muxerArgs...)
+	args = append(args, tmp)
+
+	ctx, cancel := context.WithTimeout(ctx, c.executionTimeout)
+	defer cancel()
+	out, err := c.ffmpeg.ffmpeg(ctx, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg tag writing failed for %q: %w: %s", pathToFile, err, strings.TrimSpace(string(out)))
+	}
+	return replaceFile(pathToFile, tmp)
+}
+
+// siblingTempFile reserves a hidden temporary file next to pathToFile, keeping
+// its extension so ffmpeg picks the same muxer and the final rename stays on
+// one filesystem.
+func siblingTempFile(pathToFile string) (string, error) {
+	dir, base := filepath.Split(pathToFile)
+	f, err := os.CreateTemp(dir, "."+strings.TrimSuffix(base, filepath.Ext(base))+".*.gocue"+filepath.Ext(base))
+	if err != nil {
+		return "", fmt.Errorf("cannot create temporary file for %q: %w", pathToFile, err)
+	}
+	name := f.Name()
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// replaceFile moves tmp over pathToFile, keeping the original file mode.
+func replaceFile(pathToFile, tmp string) error {
+	info, err := os.Stat(pathToFile)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, pathToFile); err != nil {
+		return fmt.Errorf("cannot replace %q with the tagged copy: %w", pathToFile, err)
+	}
+	return nil
+}
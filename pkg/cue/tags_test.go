@@ -0,0 +1,177 @@
+package cue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TagsSuite struct {
+	suite.Suite
+}
+
+func TestTagsSuite(t *testing.T) {
+	suite.Run(t, &TagsSuite{})
+}
+
+// writeTestWAV creates a minimal 8 kHz mono 16-bit WAV with 100 silent samples,
+// followed by the given extra chunks.
+func writeTestWAV(path string, extra ...[]byte) error {
+	fmtChunk := []byte("fmt \x10\x00\x00\x00\x01\x00\x01\x00\x40\x1f\x00\x00\x80\x3e\x00\x00\x02\x00\x10\x00")
+	data := append([]byte("data\xc8\x00\x00\x00"), make([]byte, 200)...)
+	body := append(append([]byte("WAVE"), fmtChunk...), data...)
+	for _, ch := range extra {
+		body = append(body, ch...)
+	}
+	hdr := []byte("RIFF\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(len(body)))
+	return os.WriteFile(path, append(hdr, body...), 0o644)
+}
+
+func (s *TagsSuite) TestWriteWAVTags() {
+	s.Run("appends an id3 chunk", func() {
+		path := filepath.Join(s.T().TempDir(), "a.wav")
+		s.Require().NoError(writeTestWAV(path))
+
+		s.Require().NoError(writeWAVTags(path, map[string]string{"liq_cue_in": "1.200"}))
+		s.Require().NoError(writeWAVTags(path, map[string]string{"liq_cue_out": "3.400"}))
+
+		s.assertSingleTag(path, "liq_cue_out", "3.400")
+	})
+
+	s.Run("replaces an id3 chunk in the middle", func() {
+		path := filepath.Join(s.T().TempDir(), "b.wav")
+		old := &id3Tag{version: 4}
+		old.set(map[string]string{"liq_cue_in": "9.900"})
+		s.Require().NoError(writeTestWAV(path, encodeID3Chunk(old), []byte("LIST\x04\x00\x00\x00INFO")))
+
+		s.Require().NoError(writeWAVTags(path, map[string]string{"liq_cue_in": "0.100"}))
+
+		s.assertSingleTag(path, "liq_cue_in", "0.100")
+		raw, err := os.ReadFile(path)
+		s.Require().NoError(err)
+		s.Contains(string(raw), "LIST\x04\x00\x00\x00INFO")
+	})
+}
+
+// assertSingleTag checks that the file is a consistent RIFF with exactly one
+// id3 chunk containing a TXXX frame for key/val.
+func (s *TagsSuite) assertSingleTag(path, key, val string) {
+	f, err := os.Open(path)
+	s.Require().NoError(err)
+	defer func() { _ = f.Close() }()
+
+	chunks, err := readRIFFChunks(f)
+	s.Require().NoError(err)
+	var tags [][]byte
+	var total int64 = 12
+	for _, ch := range chunks {
+		total += ch.size
+		if ch.id == "id3 " {
+			buf := make([]byte, ch.size)
+			_, err := f.ReadAt(buf, ch.offset)
+			s.Require().NoError(err)
+			tags = append(tags, buf)
+		}
+	}
+	s.Require().Len(tags, 1)
+	s.True(bytes.Contains(tags[0], []byte("TXXX")))
+	s.True(bytes.Contains(tags[0], append(append([]byte(key), 0), val...)))
+
+	info, err := f.Stat()
+	s.Require().NoError(err)
+	s.Equal(info.Size(), total)
+	var hdr [8]byte
+	_, err = f.ReadAt(hdr[:], 0)
+	s.Require().NoError(err)
+	s.Equal(uint32(info.Size()-8), binary.LittleEndian.Uint32(hdr[4:]))
+}
+
+func (s *TagsSuite) TestResultTags() {
+	tags, err := resultTags(&Result{CueIn: 1.5, Amplify: "-2.000 dB"})
+	s.Require().NoError(err)
+	s.Equal("1.500", tags["liq_cue_in"])
+	s.Equal("-2.000 dB", tags["liq_amplify"])
+	s.Equal("0.000", tags["duration"])
+}
+
+// TestWriteTagsOnly checks that liq_* tags written without ReplayGain ones
+// take the cached fast path.
+func (s *TagsSuite) TestWriteTagsOnly() {
+	path := filepath.Join(s.T().TempDir(), "a.wav")
+	s.Require().NoError(writeTestWAV(path))
+
+	c := NewCalculator(nil)
+	c.writeTags = true
+	s.Require().NoError(c.WriteTags(path, &Result{
+		Duration:          180.5,
+		CueIn:             1.2,
+		CueOut:            178.0,
+		CrossStartNext:    174.0,
+		Loudness:          "-14.000 LUFS",
+		LoudnessRange:     "6.000 LU",
+		Amplify:           "-4.000 dB",
+		AmplifyAdjustment: "0.000 dB",
+		ReferenceLoudness: "-18.000 LUFS",
+		TruePeak:          0.9,
+		TruePeakDb:        "-0.915 dBFS",
+	}))
+	tags, err := readWAVTags(path)
+	s.Require().NoError(err)
+	s.NotContains(tags, "replaygain_track_gain")
+
+	res, err := c.Calc(path)
+	s.Require().NoError(err)
+	s.True(res.Cached())
+	s.Equal("-4.000 dB", res.Amplify)
+	s.InDelta(178.0, res.CueOut, 1e-9)
+}
+
+func (s *TagsSuite) TestWriteTagsUnsupported() {
+	err := NewCalculator(nil).WriteTags("track.xyz", &Result{})
+	s.Equal(ErrUnsupportedFormat{ext: ".xyz"}, err)
+}
+
+func (s *TagsSuite) TestReplayGainTags() {
+	c := NewCalculator(nil)
+	res := &Result{
+		Amplify:           "-7.530 dB",
+		LoudnessRange:     "7.900 LU",
+		ReferenceLoudness: "-18.000 LUFS",
+		TruePeak:          1.632,
+	}
+
+	tags, err := c.replayGainTags(res, false)
+	s.Require().NoError(err)
+	s.Equal(map[string]string{
+		"REPLAYGAIN_TRACK_GAIN":         "-7.53 dB",
+		"REPLAYGAIN_TRACK_PEAK":         "1.632000",
+		"REPLAYGAIN_TRACK_RANGE":        "7.90 dB",
+		"REPLAYGAIN_REFERENCE_LOUDNESS": "-18.00 LUFS",
+	}, tags)
+
+	tags, err = c.replayGainTags(res, true)
+	s.Require().NoError(err)
+	// -7.53 dB at -18 LUFS is -12.53 dB relative to -23 LUFS
+	s.Equal("-3208", tags["R128_TRACK_GAIN"])
+
+	// reading it back gives the original gain, up to Q7.8 precision
+	read := map[string]string{"r128_track_gain": tags["R128_TRACK_GAIN"]}
+	c.adjustLoudness(read)
+	s.Equal("-7.531", read["replaygain_track_gain"])
+}
+
+func (s *TagsSuite) TestIsOpus() {
+	s.False(isOpus("test_data/sample.ogg"))
+
+	path := filepath.Join(s.T().TempDir(), "a.ogg")
+	page := append([]byte("OggS\x00\x02"), make([]byte, 20)...)
+	page = append(page, 1, 19)
+	page = append(page, "OpusHead"...)
+	s.Require().NoError(os.WriteFile(path, page, 0o644))
+	s.True(isOpus(path))
+}
@@ -0,0 +1,123 @@
+package cue
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const (
+	// vendor string of comment blocks created from scratch
+	vorbisVendor = "gocue"
+	// zero bytes reserved after rewritten tags (comment blocks, ID3v2 tags), so
+	// the next tag update fits in place
+	tagPadding = 4096
+)
+
+// vorbisComment - a Vorbis comment block as used by FLAC, Ogg Vorbis and Opus:
+// a vendor string followed by "KEY=value" fields
+type vorbisComment struct {
+	vendor string
+	fields []string
+}
+
+// parseVorbisComment decodes a comment block; b may carry trailing data (the
+// Vorbis framing bit, Opus padding), which is ignored.
+func parseVorbisComment(b []byte) (*vorbisComment, error) {
+	errShort := errors.New("truncated Vorbis comment block")
+	next := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return "", false
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, true
+	}
+
+	vendor, ok := next()
+	if !ok || len(b) < 4 {
+		return nil, errShort
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	vc := &vorbisComment{vendor: vendor}
+	for range count {
+		field, ok := next()
+		if !ok {
+			return nil, errShort
+		}
+		vc.fields = append(vc.fields, field)
+	}
+	return vc, nil
+}
+
+// encode returns the comment block without any framing bit or padding.
+func (vc *vorbisComment) encode() []byte {
+	size := 8 + len(vc.vendor)
+	for _, f := range vc.fields {
+		size += 4 + len(f)
+	}
+	b := make([]byte, 0, size)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(vc.vendor)))
+	b = append(b, vc.vendor...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(vc.fields)))
+	for _, f := range vc.fields {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+// get returns the value of the first field named key (case-insensitive).
+func (vc *vorbisComment) get(key string) (string, bool) {
+	for _, f := range vc.fields {
+		if k, v, ok := strings.Cut(f, "="); ok && strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// tags returns the fields as a map; of repeated keys the first one wins.
+func (vc *vorbisComment) tags() map[string]string {
+	tags := make(map[string]string, len(vc.fields))
+	for _, f := range vc.fields {
+		if k, v, ok := strings.Cut(f, "="); ok {
+			if _, dup := tags[k]; !dup {
+				tags[k] = v
+			}
+		}
+	}
+	return tags
+}
+
+// set replaces all fields named like one of tags (case-insensitive) with the
+// new values, sorted by key; all other fields are kept in their order.
+func (vc *vorbisComment) set(tags map[string]string) {
+	replaced := make(map[string]bool, len(tags))
+	for key := range tags {
+		replaced[strings.ToUpper(key)] = true
+	}
+	fields := vc.fields[:0]
+	for _, f := range vc.fields {
+		k, _, _ := strings.Cut(f, "=")
+		if !replaced[strings.ToUpper(k)] {
+			fields = append(fields, f)
+		}
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields = append(fields, key+"="+tags[key])
+	}
+	vc.fields = fields
+}
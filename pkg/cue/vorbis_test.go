@@ -0,0 +1,181 @@
+package cue
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type VorbisSuite struct {
+	suite.Suite
+}
+
+func TestVorbisSuite(t *testing.T) {
+	suite.Run(t, &VorbisSuite{})
+}
+
+func (s *VorbisSuite) TestVorbisComment() {
+	vc := &vorbisComment{vendor: "test", fields: []string{"TITLE=a", "liq_cue_in=1.000", "LIQ_CUE_IN=2.000"}}
+	vc.set(map[string]string{"liq_cue_out": "9.000", "liq_cue_in": "3.000"})
+	s.Equal([]string{"TITLE=a", "liq_cue_in=3.000", "liq_cue_out=9.000"}, vc.fields)
+
+	parsed, err := parseVorbisComment(append(vc.encode(), 1, 0, 0))
+	s.Require().NoError(err)
+	s.Equal(vc, parsed)
+	val, ok := parsed.get("LIQ_CUE_OUT")
+	s.True(ok)
+	s.Equal("9.000", val)
+
+	_, err = parseVorbisComment(vc.encode()[:20])
+	s.Error(err)
+}
+
+func (s *VorbisSuite) TestWriteOggTags() {
+	orig, err := os.ReadFile("test_data/sample.ogg")
+	s.Require().NoError(err)
+	origHeaders, err := readOggHeaders(bytes.NewReader(orig))
+	s.Require().NoError(err)
+	path := filepath.Join(s.T().TempDir(), "sample.ogg")
+	s.Require().NoError(os.WriteFile(path, orig, 0o644))
+
+	// the original comment packet is too small: the file is rewritten with padding
+	s.Require().NoError(writeOggTags(path, map[string]string{"liq_cue_in": "1.200", "REPLAYGAIN_TRACK_GAIN": "-3.10 dB"}))
+	h := s.checkOgg(path)
+	s.assertComment(h, "liq_cue_in", "1.200")
+	s.assertComment(h, "REPLAYGAIN_TRACK_GAIN", "-3.10 dB")
+	s.assertComment(h, "encoder", "Lavc61.28.100 libvorbis")
+	s.Equal(origHeaders.packets[1], h.packets[1], "setup header")
+	tagged, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	s.Equal(orig[origHeaders.end:], tagged[h.end:], "audio pages")
+
+	// the next update fits into the padding and is written in place
+	s.Require().NoError(writeOggTags(path, map[string]string{"liq_cue_in": "0.500", "liq_cue_out": "110.000"}))
+	h = s.checkOgg(path)
+	s.assertComment(h, "liq_cue_in", "0.500")
+	s.assertComment(h, "liq_cue_out", "110.000")
+	s.assertComment(h, "REPLAYGAIN_TRACK_GAIN", "-3.10 dB")
+	updated, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	s.Len(updated, len(tagged))
+}
+
+func (s *VorbisSuite) TestWriteOpusTags() {
+	var file bytes.Buffer
+	head := &oggPage{headerType: oggBOS, serial: 7, lacing: []byte{19}, body: append([]byte("OpusHead"), make([]byte, 11)...)}
+	file.Write(head.encode())
+	tags := append([]byte("OpusTags"), (&vorbisComment{vendor: "x"}).encode()...)
+	for _, p := range paginateOgg([][]byte{tags}, 7, 1) {
+		file.Write(p.encode())
+	}
+	audio := &oggPage{serial: 7, seq: 2, granule: 960, lacing: []byte{3}, body: []byte{1, 2, 3}}
+	file.Write(audio.encode())
+	path := filepath.Join(s.T().TempDir(), "a.opus")
+	s.Require().NoError(os.WriteFile(path, file.Bytes(), 0o644))
+
+	s.Require().NoError(writeOggTags(path, map[string]string{"R128_TRACK_GAIN": "-256"}))
+	h := s.checkOgg(path)
+	s.True(h.opus)
+	s.assertComment(h, "R128_TRACK_GAIN", "-256")
+	raw, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	s.Equal(audio.encode(), raw[h.end:])
+}
+
+// checkOgg verifies page checksums and sequence numbers of the whole file and
+// returns its headers.
+func (s *VorbisSuite) checkOgg(path string) *oggHeaders {
+	raw, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	r := bytes.NewReader(raw)
+	offset := int64(0)
+	for seq := uint32(0); ; seq++ {
+		p, err := readOggPage(r)
+		if err == io.EOF {
+			break
+		}
+		s.Require().NoError(err)
+		s.Require().Equal(seq, p.seq)
+		s.Require().Equal(raw[offset:offset+p.size()], p.encode(), "page %d checksum", seq)
+		offset += p.size()
+	}
+	h, err := readOggHeaders(bytes.NewReader(raw))
+	s.Require().NoError(err)
+	return h
+}
+
+func (s *VorbisSuite) assertComment(h *oggHeaders, key, val string) {
+	vc, err := h.comment()
+	s.Require().NoError(err)
+	got, ok := vc.get(key)
+	s.True(ok, key)
+	s.Equal(val, got, key)
+}
+
+func (s *VorbisSuite) TestWriteFLACTags() {
+	streamInfo := flacBlock{typ: flacStreamInfo, data: make([]byte, 34)}
+	frames := []byte{0xff, 0xf8, 1, 2, 3, 4}
+	id3 := []byte("ID3\x04\x00\x00\x00\x00\x00\x02ab")
+	flac := append(append(append([]byte{}, id3...), flacID...), encodeFLACMetadata([]flacBlock{streamInfo}, 0)...)
+	path := filepath.Join(s.T().TempDir(), "a.flac")
+	s.Require().NoError(os.WriteFile(path, append(flac, frames...), 0o644))
+
+	// no comment block and no padding: the file is rewritten
+	s.Require().NoError(writeFLACTags(path, map[string]string{"liq_cue_in": "1.200"}))
+	s.assertFLAC(path, id3, frames, "liq_cue_in", "1.200")
+	info, err := os.Stat(path)
+	s.Require().NoError(err)
+
+	// in place, within the padding
+	s.Require().NoError(writeFLACTags(path, map[string]string{"liq_cue_in": "0.300", "liq_cue_out": "9.000"}))
+	s.assertFLAC(path, id3, frames, "liq_cue_in", "0.300")
+	s.assertFLAC(path, id3, frames, "liq_cue_out", "9.000")
+	updated, err := os.Stat(path)
+	s.Require().NoError(err)
+	s.Equal(info.Size(), updated.Size())
+}
+
+// TestWriteFLACTagsLargePadding frees more padding than a single metadata
+// block can hold, so it is written back as several padding blocks.
+func (s *VorbisSuite) TestWriteFLACTagsLargePadding() {
+	streamInfo := flacBlock{typ: flacStreamInfo, data: make([]byte, 34)}
+	padding := flacBlock{typ: flacPadding, data: make([]byte, flacMaxBlockSize)}
+	frames := []byte{0xff, 0xf8, 1, 2, 3, 4}
+	flac := append(append([]byte{}, flacID...), encodeFLACMetadata([]flacBlock{streamInfo, padding}, 1<<20)...)
+	path := filepath.Join(s.T().TempDir(), "a.flac")
+	s.Require().NoError(os.WriteFile(path, append(flac, frames...), 0o644))
+
+	s.Require().NoError(writeFLACTags(path, map[string]string{"liq_cue_in": "1.200"}))
+	s.assertFLAC(path, []byte{}, frames, "liq_cue_in", "1.200")
+	info, err := os.Stat(path)
+	s.Require().NoError(err)
+	s.Equal(int64(len(flac)+len(frames)), info.Size())
+}
+
+// assertFLAC checks the leading ID3 tag, the audio frames and a comment field.
+func (s *VorbisSuite) assertFLAC(path string, id3, frames []byte, key, val string) {
+	f, err := os.Open(path)
+	s.Require().NoError(err)
+	defer func() { _ = f.Close() }()
+
+	start, blocks, metaEnd, err := readFLACMetadata(f)
+	s.Require().NoError(err)
+	s.Equal(int64(len(id3)), start)
+	s.Require().Len(blocks, 2)
+	s.Equal(byte(flacVorbisComment), blocks[1].typ)
+	vc, err := parseVorbisComment(blocks[1].data)
+	s.Require().NoError(err)
+	s.Equal(vorbisVendor, vc.vendor)
+	got, ok := vc.get(key)
+	s.True(ok)
+	s.Equal(val, got)
+
+	raw, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	s.Equal(id3, raw[:start])
+	s.Equal(frames, raw[metaEnd:])
+}
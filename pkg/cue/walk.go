@@ -0,0 +1,105 @@
+package cue
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultExtensions - audio file extensions WalkAudioFiles accepts by default;
+// video containers are deliberately left out, as analysing them is expensive
+var DefaultExtensions = []string{
+	".aac", ".aif", ".aiff", ".ape", ".flac", ".m4a", ".mp2", ".mp3",
+	".mpc", ".oga", ".ogg", ".opus", ".wav", ".wma", ".wv",
+}
+
+// WalkOptions - selects the files WalkAudioFiles reports
+type WalkOptions struct {
+	// Extensions lists the accepted file extensions (the leading dot is
+	// optional, matched case-insensitively); empty means DefaultExtensions
+	Extensions []string
+	// Include, if set, requires a file to match at least one of these globs
+	Include []string
+	// Exclude skips files and directories matching any of these globs
+	Exclude []string
+}
+
+// WalkAudioFiles - calls fn for every audio file below root, in lexical order.
+// Globs use filepath.Match syntax and are matched against both the base name
+// and the slash-separated path relative to root. Hidden files and directories
+// (including gocue's temporary files) are skipped. Symbolic links to files
+// are reported under their own path; links to directories are not followed,
+// so the walk cannot loop. Unreadable directories and dangling links do not
+// stop the walk; their errors are returned, joined, once it is done. An error
+// returned by fn stops the walk.
+func WalkAudioFiles(root string, opts *WalkOptions, fn func(path string) error) error {
+	if opts == nil {
+		opts = &WalkOptions{}
+	}
+	exts := opts.Extensions
+	if len(exts) == 0 {
+		exts = DefaultExtensions
+	}
+	var errs []error
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(d.Name(), ".") || matchAny(opts.Exclude, d.Name(), rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fileExt := strings.TrimPrefix(filepath.Ext(path), ".")
+		if !slices.ContainsFunc(exts, func(ext string) bool { return strings.EqualFold(strings.TrimPrefix(ext, "."), fileExt) }) {
+			return nil
+		}
+		if len(opts.Include) > 0 && !matchAny(opts.Include, d.Name(), rel) {
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+		return fn(path)
+	})
+	return errors.Join(append(errs, err)...)
+}
+
+// matchAny reports whether name or rel matches one of the globs; malformed
+// globs never match.
+func matchAny(globs []string, name, rel string) bool {
+	for _, g := range globs {
+		if ok, _ := filepath.Match(g, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(g, rel); ok {
+			return true
+		}
+	}
+	return false
+}
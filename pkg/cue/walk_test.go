@@ -0,0 +1,105 @@
+package cue
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type WalkSuite struct {
+	suite.Suite
+}
+
+func TestWalkSuite(t *testing.T) {
+	suite.Run(t, &WalkSuite{})
+}
+
+func (s *WalkSuite) TestWalkAudioFiles() {
+	root := s.T().TempDir()
+	for _, f := range []string{
+		"a/one.mp3", "a/two.FLAC", "a/clip.mp4", "a/.one.123.gocue.mp3",
+		".hidden/three.mp3", "live/four.ogg", "b/five.wav", "b/notes.txt",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(f))
+		s.Require().NoError(os.MkdirAll(filepath.Dir(path), 0o755))
+		s.Require().NoError(os.WriteFile(path, nil, 0o644))
+	}
+
+	walk := func(opts *WalkOptions) []string {
+		var got []string
+		err := WalkAudioFiles(root, opts, func(path string) error {
+			rel, err := filepath.Rel(root, path)
+			got = append(got, filepath.ToSlash(rel))
+			return err
+		})
+		s.Require().NoError(err)
+		return got
+	}
+
+	s.Equal([]string{"a/one.mp3", "a/two.FLAC", "b/five.wav", "live/four.ogg"}, walk(nil))
+	s.Equal([]string{"a/one.mp3", "a/two.FLAC"}, walk(&WalkOptions{Exclude: []string{"live", "b/*"}}))
+	s.Equal([]string{"a/one.mp3"}, walk(&WalkOptions{Include: []string{"*.mp3"}, Exclude: []string{"live"}}))
+	s.Equal([]string{"a/clip.mp4"}, walk(&WalkOptions{Extensions: []string{"mp4"}}))
+}
+
+// TestWalkAudioFilesUnreadable checks that a directory failing to be read
+// is reported without stopping the walk.
+func (s *WalkSuite) TestWalkAudioFilesUnreadable() {
+	root := s.T().TempDir()
+	for _, f := range []string{"a/one.mp3", "b/two.mp3", "c/three.mp3"} {
+		path := filepath.Join(root, filepath.FromSlash(f))
+		s.Require().NoError(os.MkdirAll(filepath.Dir(path), 0o755))
+		s.Require().NoError(os.WriteFile(path, nil, 0o644))
+	}
+
+	var got []string
+	err := WalkAudioFiles(root, nil, func(path string) error {
+		rel, err := filepath.Rel(root, path)
+		got = append(got, filepath.ToSlash(rel))
+		// b is listed already, but can no longer be read
+		if rel == filepath.FromSlash("a/one.mp3") {
+			s.Require().NoError(os.RemoveAll(filepath.Join(root, "b")))
+		}
+		return err
+	})
+	s.ErrorIs(err, fs.ErrNotExist)
+	s.ErrorContains(err, filepath.Join(root, "b"))
+	s.Equal([]string{"a/one.mp3", "c/three.mp3"}, got)
+
+	// an error of fn still stops the walk
+	stop := errors.New("stop")
+	got = nil
+	err = WalkAudioFiles(root, nil, func(path string) error {
+		got = append(got, path)
+		return stop
+	})
+	s.ErrorIs(err, stop)
+	s.Len(got, 1)
+}
+
+// TestWalkAudioFilesSymlinks checks that linked files are reported, linked
+// directories are not followed and dangling links are reported as errors.
+func (s *WalkSuite) TestWalkAudioFilesSymlinks() {
+	root := s.T().TempDir()
+	target := filepath.Join(s.T().TempDir(), "one.mp3")
+	s.Require().NoError(os.WriteFile(target, nil, 0o644))
+	s.Require().NoError(os.Mkdir(filepath.Join(root, "a"), 0o755))
+	s.Require().NoError(os.Symlink(target, filepath.Join(root, "a", "one.mp3")))
+	// a loop back to the root
+	s.Require().NoError(os.Symlink(root, filepath.Join(root, "a", "loop.mp3")))
+	s.Require().NoError(os.Symlink(filepath.Join(root, "missing.mp3"), filepath.Join(root, "b.mp3")))
+
+	var got []string
+	err := WalkAudioFiles(root, nil, func(path string) error {
+		rel, err := filepath.Rel(root, path)
+		got = append(got, filepath.ToSlash(rel))
+		return err
+	})
+	s.ErrorIs(err, fs.ErrNotExist)
+	s.ErrorContains(err, "b.mp3")
+	s.Equal([]string{"a/one.mp3"}, got)
+}